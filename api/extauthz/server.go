@@ -0,0 +1,144 @@
+// Package extauthz the envoy ext_authz gRPC server, it checks the tokens as the check endpoint does
+package extauthz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/quanxiang-cloud/cabin/logger"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/quanxiang-cloud/warden/internal/jwtserver"
+)
+
+// the header contract of the check endpoint
+const (
+	accessToken  = "Access-Token"
+	userID       = "User-Id"
+	userName     = "User-Name"
+	departmentID = "Department-Id"
+	tenantID     = "Tenant-Id"
+)
+
+// Checker check the access token, jwtserver.JWTServer is one
+type Checker interface {
+	CheckToken(c context.Context, header http.Header, accesstoken string) (*jwtserver.CheckTokenResponse, error)
+}
+
+// Server envoy.service.auth.v3.Authorization
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	port    string
+	checker Checker
+	grpc    *grpc.Server
+}
+
+// NewServer create the ext_authz server which listens on the port
+func NewServer(port string, checker Checker) *Server {
+	s := &Server{
+		port:    port,
+		checker: checker,
+		grpc:    grpc.NewServer(),
+	}
+	authv3.RegisterAuthorizationServer(s.grpc, s)
+	return s
+}
+
+// Run 启动服务
+func (s *Server) Run() error {
+	lis, err := net.Listen("tcp", s.port)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve serve the connections of the listener
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Close 关闭服务
+func (s *Server) Close() {
+	s.grpc.GracefulStop()
+}
+
+// Check the identity headers are added upstream when the token is valid,
+// the client headers of the same names are removed so they can't be forged
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	header := requestHeader(req)
+	token := header.Get(accessToken)
+	if token == "" {
+		token = bearerToken(header.Get("Authorization"))
+	}
+	if token == "" {
+		return unauthenticated(), nil
+	}
+
+	res, err := s.checker.CheckToken(ctx, header, token)
+	if err != nil {
+		logger.Logger.Infow("ext_authz denied", "err", err.Error())
+		return unauthenticated(), nil
+	}
+
+	ok := &authv3.OkHttpResponse{}
+	for _, v := range []struct{ key, value string }{
+		{userID, res.UserID},
+		{userName, res.Name},
+		{departmentID, res.DepID},
+		{tenantID, res.TenantID},
+	} {
+		if v.value == "" {
+			ok.HeadersToRemove = append(ok.HeadersToRemove, v.key)
+			continue
+		}
+		ok.Headers = append(ok.Headers, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: v.key, Value: v.value},
+			Append: wrapperspb.Bool(false),
+		})
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: ok,
+		},
+	}, nil
+}
+
+// requestHeader the headers of the checked request, envoy sends them in lower case
+func requestHeader(req *authv3.CheckRequest) http.Header {
+	header := http.Header{}
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	for k, v := range httpReq.GetHeaders() {
+		header.Set(k, v)
+	}
+	return header
+}
+
+func bearerToken(auth string) string {
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// unauthenticated the request is denied with 401 as the check endpoint does
+func unauthenticated() *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code.Code_UNAUTHENTICATED)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+			},
+		},
+	}
+}
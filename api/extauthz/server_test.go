@@ -0,0 +1,144 @@
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/quanxiang-cloud/warden/internal/jwtserver"
+)
+
+const validToken = "valid-token"
+
+// fakeChecker accepts validToken only
+type fakeChecker struct {
+	res *jwtserver.CheckTokenResponse
+}
+
+func (f *fakeChecker) CheckToken(c context.Context, header http.Header, accesstoken string) (*jwtserver.CheckTokenResponse, error) {
+	if accesstoken != validToken {
+		return nil, errors.New("invalid token")
+	}
+	return f.res, nil
+}
+
+func newClient(t *testing.T, checker Checker) authv3.AuthorizationClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer("", checker)
+	go s.Serve(lis)
+	t.Cleanup(s.Close)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func checkRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func TestCheckValidToken(t *testing.T) {
+	client := newClient(t, &fakeChecker{
+		res: &jwtserver.CheckTokenResponse{
+			UserID:   "user-1",
+			TenantID: "tenant-1",
+		},
+	})
+
+	for name, headers := range map[string]map[string]string{
+		"access-token": {"access-token": validToken},
+		"bearer":       {"authorization": "Bearer " + validToken},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// the identity headers of the client are forged
+			headers["user-id"] = "forged"
+			headers["user-name"] = "forged"
+			headers["department-id"] = "forged"
+
+			resp, err := client.Check(context.Background(), checkRequest(headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetStatus().GetCode() != int32(code.Code_OK) {
+				t.Fatalf("status %d, want OK", resp.GetStatus().GetCode())
+			}
+			ok := resp.GetOkResponse()
+			if ok == nil {
+				t.Fatal("no ok response")
+			}
+
+			added := map[string]string{}
+			for _, v := range ok.GetHeaders() {
+				if v.GetAppend().GetValue() {
+					t.Errorf("header %s is appended, the forged value would be kept", v.GetHeader().GetKey())
+				}
+				added[v.GetHeader().GetKey()] = v.GetHeader().GetValue()
+			}
+			if added[userID] != "user-1" || added[tenantID] != "tenant-1" || len(added) != 2 {
+				t.Errorf("headers %v, want User-Id and Tenant-Id of the token", added)
+			}
+
+			removed := map[string]bool{}
+			for _, v := range ok.GetHeadersToRemove() {
+				removed[v] = true
+			}
+			if !removed[userName] || !removed[departmentID] || len(removed) != 2 {
+				t.Errorf("removed headers %v, want User-Name and Department-Id", ok.GetHeadersToRemove())
+			}
+		})
+	}
+}
+
+func TestCheckDenied(t *testing.T) {
+	client := newClient(t, &fakeChecker{
+		res: &jwtserver.CheckTokenResponse{UserID: "user-1"},
+	})
+
+	for name, headers := range map[string]map[string]string{
+		"missing token": {"user-id": "forged"},
+		"not bearer":    {"authorization": "Basic " + validToken},
+		"invalid token": {"access-token": "invalid-token"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Check(context.Background(), checkRequest(headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetStatus().GetCode() != int32(code.Code_UNAUTHENTICATED) {
+				t.Fatalf("status %d, want UNAUTHENTICATED", resp.GetStatus().GetCode())
+			}
+			denied := resp.GetDeniedResponse()
+			if denied == nil {
+				t.Fatal("no denied response")
+			}
+			if denied.GetStatus().GetCode() != typev3.StatusCode_Unauthorized {
+				t.Errorf("http status %v, want 401", denied.GetStatus().GetCode())
+			}
+		})
+	}
+}
@@ -1,12 +1,23 @@
 package restful
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/go-redis/redis/v8"
 	error2 "github.com/quanxiang-cloud/cabin/error"
 	"github.com/quanxiang-cloud/cabin/logger"
 	ginheader "github.com/quanxiang-cloud/cabin/tailormade/header"
 	"github.com/quanxiang-cloud/cabin/tailormade/resp"
 	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/device"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/generates"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/server"
 	"net/http/httputil"
 	"net/url"
 	"strings"
@@ -31,6 +42,8 @@ const (
 	noPass = "false"
 )
 
+const defaultJWKSMaxAge = time.Hour
+
 //header profile
 const (
 	_userID       = "User-Id"
@@ -42,16 +55,29 @@ const (
 // JWTApi JWTApi
 type JWTApi struct {
 	repo jwtserver.JWTServer
+
+	jwksMaxAge time.Duration
+	proxies    device.Proxies
 }
 
 // NewJWTApi NewJWTApi
-func NewJWTApi(conf configs.Config, redisClient redis.UniversalClient, log logger.AdaptedLogger) (*JWTApi, error) {
-	jwtImpl, err := jwtserver.NewJWTImpl(conf, redisClient)
+func NewJWTApi(ctx context.Context, conf configs.Config, s *server.Server, ring *generates.KeyRing, deny *jwtserver.Denylist, redisClient redis.UniversalClient, log logger.AdaptedLogger) (*JWTApi, error) {
+	jwtImpl, err := jwtserver.NewJWTImpl(ctx, conf, s, ring, deny, redisClient)
+	if err != nil {
+		return nil, err
+	}
+	jwksMaxAge := conf.JWTConfig.JWKSMaxAge * time.Second
+	if jwksMaxAge <= 0 {
+		jwksMaxAge = defaultJWKSMaxAge
+	}
+	proxies, err := device.ParseProxies(conf.TrustedProxies)
 	if err != nil {
 		return nil, err
 	}
 	return &JWTApi{
-		repo: jwtImpl,
+		repo:       jwtImpl,
+		jwksMaxAge: jwksMaxAge,
+		proxies:    proxies,
 	}, nil
 }
 
@@ -63,11 +89,16 @@ func (j *JWTApi) LoginHandler(c *gin.Context) {
 		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
 		return
 	}
+	r.ClientIP, r.UserAgent = j.proxies.ClientIP(c.Request), device.UserAgent(c.Request)
 	res, err := j.repo.Login(ginheader.MutateContext(c), r)
 	if err != nil {
 		resp.Format(nil, err).Context(c)
 		return
 	}
+	if res.MFA != nil {
+		resp.Format(res.MFA, nil).Context(c)
+		return
+	}
 	resp.Format(res.Token, nil).Context(c)
 
 }
@@ -91,7 +122,11 @@ func (j *JWTApi) Refresh(c *gin.Context) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
-	res, err := j.repo.Refresh(ginheader.MutateContext(c), refreshToken)
+	res, err := j.repo.Refresh(ginheader.MutateContext(c), &jwtserver.RefreshRequest{
+		RefreshToken: refreshToken,
+		ClientIP:     j.proxies.ClientIP(c.Request),
+		UserAgent:    device.UserAgent(c.Request),
+	})
 	if err != nil {
 		resp.Format(nil, err).Context(c)
 		return
@@ -189,6 +224,265 @@ func (j *JWTApi) SwitchTenant(c *gin.Context) {
 
 }
 
+// JWKS publish the public keys as RFC 7517 json web key set
+func (j *JWTApi) JWKS(c *gin.Context) {
+	set, err := j.repo.JWKS(ginheader.MutateContext(c))
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(set)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(j.jwksMaxAge.Seconds())))
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// Keys list the signing keys
+func (j *JWTApi) Keys(c *gin.Context) {
+	resp.Format(j.repo.Keys(ginheader.MutateContext(c))).Context(c)
+}
+
+// RotateKey rotate the signing key
+func (j *JWTApi) RotateKey(c *gin.Context) {
+	r := &jwtserver.RotateKeyRequest{}
+	err := c.ShouldBind(r)
+	if err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.RotateKey(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// Sessions list the sessions of the user of the access token
+func (j *JWTApi) Sessions(c *gin.Context) {
+	accessToken := c.GetHeader(AccessToken)
+	if accessToken == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	resp.Format(j.repo.ListSessions(ginheader.MutateContext(c), &jwtserver.ListSessionsRequest{
+		Token: accessToken,
+	})).Context(c)
+}
+
+// RevokeSession revoke one session of the user of the access token
+func (j *JWTApi) RevokeSession(c *gin.Context) {
+	r := &jwtserver.RevokeSessionRequest{}
+	err := c.ShouldBind(r)
+	if err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	accessToken := c.GetHeader(AccessToken)
+	if accessToken == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	r.UserID, r.Token = "", accessToken
+	resp.Format(j.repo.RevokeSession(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// AdminSessions list the sessions of a user
+func (j *JWTApi) AdminSessions(c *gin.Context) {
+	r := &jwtserver.ListSessionsRequest{}
+	err := c.ShouldBind(r)
+	if err != nil || r.UserID == "" {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.ListSessions(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// AdminRevokeSession revoke one session of a user
+func (j *JWTApi) AdminRevokeSession(c *gin.Context) {
+	r := &jwtserver.RevokeSessionRequest{}
+	err := c.ShouldBind(r)
+	if err != nil || r.UserID == "" {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.RevokeSession(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// LoginTypes the enabled login types for the login page
+func (j *JWTApi) LoginTypes(c *gin.Context) {
+	resp.Format(j.repo.LoginTypes(ginheader.MutateContext(c))).Context(c)
+}
+
+// LoginMFA exchange the mfa challenge of the login and the code for the tokens
+func (j *JWTApi) LoginMFA(c *gin.Context) {
+	r := &jwtserver.LoginMFARequest{}
+	if err := c.ShouldBind(r); err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	r.ClientIP, r.UserAgent = j.proxies.ClientIP(c.Request), device.UserAgent(c.Request)
+	res, err := j.repo.LoginMFA(ginheader.MutateContext(c), r)
+	if err != nil {
+		resp.Format(nil, err).Context(c)
+		return
+	}
+	resp.Format(res.Token, nil).Context(c)
+}
+
+// mfaRequest the mfa request of the user of the access token
+func (j *JWTApi) mfaRequest(c *gin.Context) (*jwtserver.MFARequest, bool) {
+	r := &jwtserver.MFARequest{}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(r); err != nil {
+			resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+			return nil, false
+		}
+	}
+	r.Token = c.GetHeader(AccessToken)
+	if r.Token == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+	r.ClientIP = j.proxies.ClientIP(c.Request)
+	return r, true
+}
+
+// MFAStatus whether the user has enabled mfa
+func (j *JWTApi) MFAStatus(c *gin.Context) {
+	if r, ok := j.mfaRequest(c); ok {
+		resp.Format(j.repo.MFAStatus(ginheader.MutateContext(c), r)).Context(c)
+	}
+}
+
+// EnrollMFA a new TOTP secret of the user
+func (j *JWTApi) EnrollMFA(c *gin.Context) {
+	if r, ok := j.mfaRequest(c); ok {
+		resp.Format(j.repo.EnrollMFA(ginheader.MutateContext(c), r)).Context(c)
+	}
+}
+
+// ActivateMFA enable mfa by the first code of the enrolled secret, the recovery codes are shown once
+func (j *JWTApi) ActivateMFA(c *gin.Context) {
+	if r, ok := j.mfaRequest(c); ok {
+		resp.Format(j.repo.ActivateMFA(ginheader.MutateContext(c), r)).Context(c)
+	}
+}
+
+// DisableMFA disable mfa by a code
+func (j *JWTApi) DisableMFA(c *gin.Context) {
+	if r, ok := j.mfaRequest(c); ok {
+		resp.Format(j.repo.DisableMFA(ginheader.MutateContext(c), r)).Context(c)
+	}
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of the user
+func (j *JWTApi) RegenerateRecoveryCodes(c *gin.Context) {
+	if r, ok := j.mfaRequest(c); ok {
+		resp.Format(j.repo.RegenerateRecoveryCodes(ginheader.MutateContext(c), r)).Context(c)
+	}
+}
+
+// ResetMFA the admin removes the mfa of a user and destroys the tokens of the user
+func (j *JWTApi) ResetMFA(c *gin.Context) {
+	r := &jwtserver.ResetMFARequest{}
+	if err := c.ShouldBind(r); err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.ResetMFA(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// Lockout the lock state of a username or a client ip
+func (j *JWTApi) Lockout(c *gin.Context) {
+	r := &jwtserver.LockoutRequest{}
+	if err := c.ShouldBind(r); err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.Lockout(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// ClearLockout clear the lock of a username or a client ip
+func (j *JWTApi) ClearLockout(c *gin.Context) {
+	r := &jwtserver.LockoutRequest{}
+	if err := c.ShouldBind(r); err != nil {
+		resp.Format(nil, error2.New(code.InvalidParams)).Context(c)
+		return
+	}
+	resp.Format(j.repo.ClearLockout(ginheader.MutateContext(c), r)).Context(c)
+}
+
+// Authorize RFC 6749 authorization endpoint
+func (j *JWTApi) Authorize(c *gin.Context) {
+	_ = j.repo.Authorize(c.Writer, c.Request.WithContext(ginheader.MutateContext(c)))
+}
+
+// OAuthToken RFC 6749 token endpoint, it responds in the oauth2 format instead of resp.Format
+func (j *JWTApi) OAuthToken(c *gin.Context) {
+	_ = j.repo.OAuthToken(c.Writer, c.Request.WithContext(ginheader.MutateContext(c)))
+}
+
+// Introspect RFC 7662 token introspection endpoint
+func (j *JWTApi) Introspect(c *gin.Context) {
+	_ = j.repo.Introspect(c.Writer, c.Request.WithContext(ginheader.MutateContext(c)))
+}
+
+// Revoke RFC 7009 token revocation endpoint
+func (j *JWTApi) Revoke(c *gin.Context) {
+	_ = j.repo.Revoke(c.Writer, c.Request.WithContext(ginheader.MutateContext(c)))
+}
+
+// ForwardAuth forward auth endpoint of traefik, nginx auth_request and caddy
+func (j *JWTApi) ForwardAuth(c *gin.Context) {
+	_ = j.repo.ForwardAuth(c.Writer, c.Request.WithContext(ginheader.MutateContext(c)))
+}
+
+// OpenIDConfiguration OpenID Connect discovery document
+func (j *JWTApi) OpenIDConfiguration(c *gin.Context) {
+	doc, err := j.repo.Discovery(ginheader.MutateContext(c))
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(j.jwksMaxAge.Seconds())))
+	c.JSON(http.StatusOK, doc)
+}
+
+// UserInfo OpenID Connect userinfo endpoint, the access token is a bearer token
+func (j *JWTApi) UserInfo(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || token == c.GetHeader("Authorization") {
+		token = c.GetHeader(AccessToken)
+	}
+	claims, err := j.repo.UserInfo(ginheader.MutateContext(c), token)
+	if err != nil {
+		status, code := http.StatusUnauthorized, errors.ErrInvalidToken
+		if err == errors.ErrInsufficientScope {
+			status, code = http.StatusForbidden, errors.ErrInsufficientScope
+		} else if err != errors.ErrInvalidToken {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, code.Error()))
+		c.AbortWithStatus(status)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, claims)
+}
+
+// Reload reload the config
+func (j *JWTApi) Reload(ctx context.Context, conf configs.Config) error {
+	return j.repo.ReloadKeys(ctx, conf.JWTConfig)
+}
+
 // IndexHandler IndexHandler
 func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	host := strings.Split(r.Host, ".")[0]
@@ -9,6 +9,7 @@ import (
 	"github.com/quanxiang-cloud/warden/internal/org"
 	"github.com/quanxiang-cloud/warden/pkg/code"
 	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/server"
 )
 
 // Org org
@@ -17,9 +18,9 @@ type Org struct {
 }
 
 // NewOrg new
-func NewOrg(conf configs.Config, redisClient redis.UniversalClient) (*Org, error) {
+func NewOrg(conf configs.Config, s *server.Server, redisClient redis.UniversalClient) (*Org, error) {
 	return &Org{
-		orgs: org.NewOrg(conf, redisClient),
+		orgs: org.NewOrg(conf, s, redisClient),
 	}, nil
 }
 
@@ -0,0 +1,104 @@
+package restful
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+	ginheader "github.com/quanxiang-cloud/cabin/tailormade/header"
+	"github.com/quanxiang-cloud/cabin/tailormade/resp"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/ratelimit"
+)
+
+// keys of the rate limit rules
+const (
+	rateLimitIP     = "ip"
+	rateLimitUser   = "user"
+	rateLimitClient = "client"
+)
+
+// rateLimit limit the requests of the routes by the rules, the requests pass when redis fails
+type rateLimit struct {
+	limiter *ratelimit.Limiter
+	rules   []configs.RateLimitRule
+	jwtAPI  *JWTApi
+}
+
+// newRateLimit the rate limit middleware, nil when it is off
+func newRateLimit(conf configs.RateLimit, redisClient redis.UniversalClient, jwtAPI *JWTApi) gin.HandlerFunc {
+	if !conf.Enable || len(conf.Rules) == 0 {
+		return nil
+	}
+	r := &rateLimit{
+		limiter: ratelimit.New(redisClient),
+		rules:   conf.Rules,
+		jwtAPI:  jwtAPI,
+	}
+	return r.handle
+}
+
+func (r *rateLimit) handle(c *gin.Context) {
+	path := c.FullPath()
+	for _, rule := range r.rules {
+		if rule.Limit <= 0 || rule.Window <= 0 || !matchRoute(rule.Path, path) {
+			continue
+		}
+		key := rule.Path + ":" + r.key(c, rule.Key)
+		ok, retryAfter, err := r.limiter.Allow(c.Request.Context(), key, rule.Limit, rule.Window*time.Second)
+		if err != nil {
+			logger.Logger.Errorw("rate limit", "key", key, "err", err.Error())
+			continue
+		}
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.Abort()
+			resp.Format(nil, error2.New(code.ErrTooManyRequests)).Context(c, http.StatusTooManyRequests)
+			return
+		}
+	}
+	c.Next()
+}
+
+// matchRoute a path ending with * matches by prefix
+func matchRoute(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == path
+}
+
+// key the user or the client of the request, the client ip when it has neither
+func (r *rateLimit) key(c *gin.Context, kind string) string {
+	switch kind {
+	case rateLimitUser:
+		access := c.GetHeader(AccessToken)
+		if access == "" {
+			access = bearer(c.GetHeader("Authorization"))
+		}
+		if userID := r.jwtAPI.repo.TokenUser(ginheader.MutateContext(c), access, c.GetHeader(RefreshToken)); userID != "" {
+			return rateLimitUser + ":" + userID
+		}
+	case rateLimitClient:
+		if clientID := r.jwtAPI.repo.ClientID(c.Request); clientID != "" {
+			return rateLimitClient + ":" + clientID
+		}
+	}
+	return rateLimitIP + ":" + r.jwtAPI.proxies.ClientIP(c.Request)
+}
+
+func bearer(auth string) string {
+	const prefix = "Bearer "
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return auth[len(prefix):]
+	}
+	return ""
+}
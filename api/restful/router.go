@@ -3,6 +3,8 @@ package restful
 import (
 	"context"
 	"github.com/quanxiang-cloud/cabin/logger"
+	"github.com/quanxiang-cloud/warden/api/extauthz"
+	"github.com/quanxiang-cloud/warden/internal/jwtserver"
 	"github.com/quanxiang-cloud/warden/pkg/configs"
 	"github.com/quanxiang-cloud/warden/pkg/probe"
 	"github.com/quanxiang-cloud/warden/pkg/util"
@@ -24,6 +26,8 @@ type Router struct {
 	c *configs.Config
 
 	engine *gin.Engine
+	jwtAPI *JWTApi
+	authz  *extauthz.Server
 }
 
 // NewRouter 开启路由
@@ -36,21 +40,60 @@ func NewRouter(ctx context.Context, c *configs.Config, log logger.AdaptedLogger)
 	if err != nil {
 		panic(err)
 	}
-	jwtAPI, err := NewJWTApi(*c, redisClient, log)
+	ring, err := jwtserver.NewKeyRing(c.JWTConfig)
 	if err != nil {
 		return nil, err
 	}
-	newOrg, err := NewOrg(*c, redisClient)
+	// org and jwt api share one server, so they see the same token store
+	deny := jwtserver.NewDenylist(c.JWTConfig, redisClient)
+	s := jwtserver.NewServer(ring, deny)
+	jwtAPI, err := NewJWTApi(ctx, *c, s, ring, deny, redisClient, log)
+	if err != nil {
+		return nil, err
+	}
+	newOrg, err := NewOrg(*c, s, redisClient)
 	k := engine.Group("/api/v1/warden")
+	if limit := newRateLimit(c.RateLimit, redisClient, jwtAPI); limit != nil {
+		k.Use(limit)
+	}
 	{
 		k.Any("/login", jwtAPI.LoginHandler)   //ok
 		k.Any("/logout", jwtAPI.LogOutHandler) //ok
 		k.Any("/refresh", jwtAPI.Refresh)      //ok
+		k.POST("/login/mfa", jwtAPI.LoginMFA)
+		k.GET("/login/types", jwtAPI.LoginTypes)
 
 		k.Any("/auth", jwtAPI.Auth)
 		k.Any("/destroy", jwtAPI.DestroyByUserID)
 		k.Any("/check", jwtAPI.CheckToken)           //ok
 		k.Any("/switch/tenant", jwtAPI.SwitchTenant) //ok
+		k.Any("/forward-auth", jwtAPI.ForwardAuth)
+
+		k.GET("/oauth/authorize", jwtAPI.Authorize)
+		k.POST("/oauth/authorize", jwtAPI.Authorize)
+		k.POST("/oauth/token", jwtAPI.OAuthToken)
+		k.POST("/oauth/introspect", jwtAPI.Introspect)
+		k.POST("/oauth/revoke", jwtAPI.Revoke)
+		k.GET("/oauth/userinfo", jwtAPI.UserInfo)
+		k.POST("/oauth/userinfo", jwtAPI.UserInfo)
+
+		k.GET("/session/h/list", jwtAPI.Sessions)
+		k.POST("/session/h/revoke", jwtAPI.RevokeSession)
+		k.GET("/session/m/list", jwtAPI.AdminSessions)
+		k.POST("/session/m/revoke", jwtAPI.AdminRevokeSession)
+
+		k.GET("/mfa/h/status", jwtAPI.MFAStatus)
+		k.POST("/mfa/h/enroll", jwtAPI.EnrollMFA)
+		k.POST("/mfa/h/activate", jwtAPI.ActivateMFA)
+		k.POST("/mfa/h/disable", jwtAPI.DisableMFA)
+		k.POST("/mfa/h/recovery/regenerate", jwtAPI.RegenerateRecoveryCodes)
+		k.POST("/mfa/m/reset", jwtAPI.ResetMFA)
+
+		k.GET("/lockout/m/get", jwtAPI.Lockout)
+		k.POST("/lockout/m/clear", jwtAPI.ClearLockout)
+
+		k.GET("/key/m/list", jwtAPI.Keys)
+		k.POST("/key/m/rotate", jwtAPI.RotateKey)
 
 		k.POST("/org/m/user/update/status", newOrg.UpdateUserStatus)          //ok
 		k.POST("/org/m/user/updates/status", newOrg.UpdateListUserStatus)     //ok
@@ -60,6 +103,8 @@ func NewRouter(ctx context.Context, c *configs.Config, log logger.AdaptedLogger)
 
 	}
 	engine.Any("/authCoder", jwtAPI.AuthCoder)
+	engine.GET("/.well-known/jwks.json", jwtAPI.JWKS)
+	engine.GET("/.well-known/openid-configuration", jwtAPI.OpenIDConfiguration)
 	{
 		probe := probe.New(util.LoggerFromContext(ctx))
 		engine.GET("liveness", func(c *gin.Context) {
@@ -71,10 +116,15 @@ func NewRouter(ctx context.Context, c *configs.Config, log logger.AdaptedLogger)
 		})
 
 	}
-	return &Router{
+	router := &Router{
 		c:      c,
 		engine: engine,
-	}, nil
+		jwtAPI: jwtAPI,
+	}
+	if c.ExtAuthz.Port != "" {
+		router.authz = extauthz.NewServer(c.ExtAuthz.Port, jwtAPI.repo)
+	}
+	return router, nil
 }
 
 func newRouter(c *configs.Config) (*gin.Engine, error) {
@@ -89,9 +139,24 @@ func newRouter(c *configs.Config) (*gin.Engine, error) {
 
 // Run 启动服务
 func (r *Router) Run() {
+	if r.authz != nil {
+		go func() {
+			if err := r.authz.Run(); err != nil {
+				logger.Logger.Errorw("run ext_authz server", "err", err.Error())
+			}
+		}()
+	}
 	r.engine.Run(r.c.Port)
 }
 
+// Reload 重新加载配置
+func (r *Router) Reload(ctx context.Context, c *configs.Config) error {
+	return r.jwtAPI.Reload(ctx, *c)
+}
+
 // Close 关闭服务
 func (r *Router) Close() {
+	if r.authz != nil {
+		r.authz.Close()
+	}
 }
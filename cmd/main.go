@@ -45,6 +45,13 @@ func main() {
 			router.Close()
 			return
 		case syscall.SIGHUP:
+			if err := configs.NewConfig(*configPath); err != nil {
+				log.Errorw("reload config", "err", err.Error())
+				continue
+			}
+			if err := router.Reload(ctx, configs.GetConfig()); err != nil {
+				log.Errorw("reload router", "err", err.Error())
+			}
 		default:
 			return
 		}
@@ -3,15 +3,22 @@ module github.com/quanxiang-cloud/warden
 go 1.16
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/alicebob/miniredis/v2 v2.17.0
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/envoyproxy/go-control-plane v0.10.1
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-logr/logr v1.2.2
 	github.com/go-logr/zapr v1.2.2
 	github.com/go-redis/redis/v8 v8.11.4
 	github.com/google/uuid v1.3.0
 	github.com/json-iterator/go v1.1.12
+	github.com/lib/pq v1.10.4
 	github.com/quanxiang-cloud/cabin v0.0.6
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.19.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.45.0
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.17.3
 )
@@ -0,0 +1,199 @@
+package jwtserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/tailormade/client"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+)
+
+// kinds of the authenticators
+const (
+	authenticatorOrg = "org"
+)
+
+// Credentials the credentials of a login
+type Credentials struct {
+	LoginType string
+	UserName  string
+	Password  string
+	ClientIP  string
+}
+
+// Account the account of the credentials, the user id is empty when they are refused,
+// the code and the msg tell why
+type Account struct {
+	UserID string
+	Code   int64
+	Msg    string
+}
+
+// Authenticator the backend which checks the credentials of a login type,
+// the error is for the backend failure, not for the refused credentials
+type Authenticator interface {
+	Authenticate(ctx context.Context, cred *Credentials) (*Account, error)
+}
+
+// AuthenticatorFactory create the authenticator of a login type by the options of it
+type AuthenticatorFactory func(conf configs.Config, options map[string]string) (Authenticator, error)
+
+var errUnknownLoginType = error2.New(code.ErrUnknownLoginType)
+
+var authenticatorFactories = map[string]AuthenticatorFactory{
+	authenticatorOrg: newOrgAuthenticator,
+}
+
+// RegisterAuthenticator register the backend of the kind, it is called before NewJWTImpl
+func RegisterAuthenticator(kind string, factory AuthenticatorFactory) {
+	authenticatorFactories[kind] = factory
+}
+
+// OrgCheckRequest 用于调用org服务
+type OrgCheckRequest struct {
+	UserName string `json:"username"` //多形态:邮箱、手机、其它
+	Password string `json:"password"`
+	Types    string `json:"types"` //登录模式
+}
+
+// OrgCheckResponse 返回用户信息
+type OrgCheckResponse struct {
+	UserID    string `json:"userID"`
+	UseStatus int    `json:"useStatus"` //状态：1正常，-2禁用，-1删除 （与账号库相同）
+	Code      int64  `json:"code"`      //错误码
+	Msg       string `json:"msg"`       //错误信息
+}
+
+// orgAuthenticator the account and the password are checked by the org service
+type orgAuthenticator struct {
+	client http.Client
+	url    string
+}
+
+// orgLoginURI the option which overrides the login uri of the org service
+const orgLoginURI = "loginURI"
+
+func newOrgAuthenticator(conf configs.Config, options map[string]string) (Authenticator, error) {
+	loginURI := conf.OrgAPIs.LoginURI
+	if v := options[orgLoginURI]; v != "" {
+		loginURI = v
+	}
+	return &orgAuthenticator{
+		client: client.New(conf.InternalNet),
+		url:    conf.OrgAPIs.Host + loginURI,
+	}, nil
+}
+
+func (o *orgAuthenticator) Authenticate(ctx context.Context, cred *Credentials) (*Account, error) {
+	loginReq := OrgCheckRequest{
+		UserName: cred.UserName,
+		Password: cred.Password,
+		Types:    cred.LoginType,
+	}
+	userAccount := &OrgCheckResponse{}
+	err := client.POST(ctx, &o.client, o.url, loginReq, userAccount)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		UserID: userAccount.UserID,
+		Code:   userAccount.Code,
+		Msg:    userAccount.Msg,
+	}, nil
+}
+
+// LoginType a login type of the login page
+type LoginType struct {
+	LoginType string `json:"loginType"`
+	Name      string `json:"name"`
+}
+
+// LoginTypesResponse the enabled login types
+type LoginTypesResponse struct {
+	LoginTypes []LoginType `json:"loginTypes"`
+}
+
+// authenticators the authenticators by the login type,
+// every login type goes to the fallback when none is configured
+type authenticators struct {
+	byType   map[string]Authenticator
+	types    []LoginType
+	fallback Authenticator
+}
+
+func newAuthenticators(conf configs.Config) (*authenticators, error) {
+	if len(conf.Authenticators) == 0 {
+		fallback, err := newOrgAuthenticator(conf, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &authenticators{
+			fallback: fallback,
+		}, nil
+	}
+
+	a := &authenticators{
+		byType: make(map[string]Authenticator, len(conf.Authenticators)),
+		types:  make([]LoginType, 0, len(conf.Authenticators)),
+	}
+	for _, v := range conf.Authenticators {
+		if v.Disable {
+			continue
+		}
+		if v.LoginType == "" {
+			return nil, fmt.Errorf("authenticator without login type")
+		}
+		if _, ok := a.byType[v.LoginType]; ok {
+			return nil, fmt.Errorf("authenticator of login type %s is duplicated", v.LoginType)
+		}
+		kind := v.Kind
+		if kind == "" {
+			kind = authenticatorOrg
+		}
+		factory, ok := authenticatorFactories[kind]
+		if !ok {
+			return nil, fmt.Errorf("authenticator kind %s of login type %s is unknown", kind, v.LoginType)
+		}
+		auth, err := factory(conf, v.Options)
+		if err != nil {
+			return nil, fmt.Errorf("authenticator of login type %s: %w", v.LoginType, err)
+		}
+		name := v.Name
+		if name == "" {
+			name = v.LoginType
+		}
+		a.byType[v.LoginType] = auth
+		a.types = append(a.types, LoginType{
+			LoginType: v.LoginType,
+			Name:      name,
+		})
+	}
+	return a, nil
+}
+
+// authenticate check the credentials by the authenticator of the login type
+func (a *authenticators) authenticate(ctx context.Context, cred *Credentials) (*Account, error) {
+	auth := a.fallback
+	if a.byType != nil {
+		auth = a.byType[cred.LoginType]
+	}
+	if auth == nil {
+		return nil, errUnknownLoginType
+	}
+	return auth.Authenticate(ctx, cred)
+}
+
+// LoginTypes the enabled login types in the configured order, it is empty when none is configured
+func (j *jwtServer) LoginTypes(c context.Context) (*LoginTypesResponse, error) {
+	types := j.auth.types
+	if types == nil {
+		types = []LoginType{}
+	}
+	return &LoginTypesResponse{
+		LoginTypes: types,
+	}, nil
+}
@@ -0,0 +1,254 @@
+package jwtserver
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis/v8"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+)
+
+const (
+	// wardenDenylist revoked "jti:<id>" and "sid:<id>" -> unix time the entry expires
+	wardenDenylist = "warden:denylist"
+
+	denylistJTI = "jti:"
+	denylistSID = "sid:"
+
+	defaultDenylistSyncInterval = 5 * time.Second
+	// denylistStaleSyncs the denylist isn't trusted after this many syncs failed
+	denylistStaleSyncs = 3
+	// maxDenylistExp the entries expire after it when no lifetime of the tokens is bounded,
+	// so the denylist doesn't grow forever
+	maxDenylistExp = 30 * 24 * time.Hour
+)
+
+// Denylist the revoked access tokens and sessions of the stateless verification,
+// every instance keeps a copy in memory which it syncs from redis.
+// A revocation takes effect on the other instances within one sync interval.
+type Denylist struct {
+	redisc   redis.UniversalClient
+	interval time.Duration
+	// sessionExp how long a token of a revoked session may still be presented
+	sessionExp time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]float64
+	syncAt  time.Time
+}
+
+// NewDenylist the denylist of the stateless verification, nil when it is off
+func NewDenylist(conf configs.JWTConfig, redisc redis.UniversalClient) *Denylist {
+	if !conf.Stateless.Enable {
+		return nil
+	}
+	interval := conf.Stateless.SyncInterval * time.Second
+	if interval <= 0 {
+		interval = defaultDenylistSyncInterval
+	}
+	return &Denylist{
+		redisc:     redisc,
+		interval:   interval,
+		sessionExp: denylistExp(conf),
+		entries:    make(map[string]float64),
+	}
+}
+
+// denylistExp the longest an access token lives, the refresh lifetime when an access token
+// doesn't expire as the session lives that long, maxDenylistExp when neither is bounded
+func denylistExp(conf configs.JWTConfig) time.Duration {
+	if exp := maxTokenExp(conf, func(v configs.TokenLifetime) time.Duration { return v.AccessTokenExp }); exp > 0 {
+		return exp
+	}
+	if exp := maxTokenExp(conf, func(v configs.TokenLifetime) time.Duration { return v.RefreshTokenExp }); exp > 0 {
+		return exp
+	}
+	return maxDenylistExp
+}
+
+// maxTokenExp the longest lifetime of the global and the override config, 0 when the global one
+// doesn't expire. A zero override keeps the global lifetime.
+func maxTokenExp(conf configs.JWTConfig, lifetime func(configs.TokenLifetime) time.Duration) time.Duration {
+	exp := lifetime(configs.TokenLifetime{
+		AccessTokenExp:  conf.AccessTokenExp,
+		RefreshTokenExp: conf.RefreshTokenExp,
+	})
+	if exp == 0 {
+		return 0
+	}
+	for _, overrides := range []map[string]configs.TokenLifetime{conf.LoginTypes, conf.Clients} {
+		for _, v := range overrides {
+			if lifetime(v) > exp {
+				exp = lifetime(v)
+			}
+		}
+	}
+	return exp * time.Hour
+}
+
+// Denied whether the token or its session has been revoked
+func (d *Denylist) Denied(jti, sid string) bool {
+	now := float64(time.Now().Unix())
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if exp, ok := d.entries[denylistJTI+jti]; ok && jti != "" && exp > now {
+		return true
+	}
+	if exp, ok := d.entries[denylistSID+sid]; ok && sid != "" && exp > now {
+		return true
+	}
+	return false
+}
+
+// Fresh whether the denylist has been synced lately, a stale one can't be trusted
+func (d *Denylist) Fresh() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return time.Since(d.syncAt) < d.interval*denylistStaleSyncs
+}
+
+// DenySession deny the access tokens of the session which haven't expired yet
+func (d *Denylist) DenySession(ctx context.Context, sid string) {
+	if sid == "" {
+		return
+	}
+	d.add(ctx, denylistSID+sid, float64(time.Now().Add(d.sessionExp).Unix()))
+}
+
+// DenyToken deny one access token until it expires, the token has been verified when it was issued
+func (d *Denylist) DenyToken(ctx context.Context, token string) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return
+	}
+	exp := float64(time.Now().Add(d.sessionExp).Unix())
+	if v, ok := claims["exp"].(float64); ok {
+		exp = v
+	}
+	d.add(ctx, denylistJTI+jti, exp)
+}
+
+func (d *Denylist) add(ctx context.Context, member string, exp float64) {
+	d.mu.Lock()
+	d.entries[member] = exp
+	d.mu.Unlock()
+
+	err := d.redisc.ZAdd(ctx, wardenDenylist, &redis.Z{Score: exp, Member: member}).Err()
+	if err != nil {
+		logger.Logger.Errorw("add to the denylist", "member", member, "err", err.Error())
+	}
+}
+
+// sync replace the copy in memory with the entries which haven't expired
+func (d *Denylist) sync(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := d.redisc.ZRemRangeByScore(ctx, wardenDenylist, "-inf", now).Err(); err != nil {
+		return err
+	}
+	zs, err := d.redisc.ZRangeByScoreWithScores(ctx, wardenDenylist, &redis.ZRangeBy{
+		Min: "(" + now,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]float64, len(zs))
+	for _, z := range zs {
+		if member, ok := z.Member.(string); ok {
+			entries[member] = z.Score
+		}
+	}
+
+	d.mu.Lock()
+	d.entries = entries
+	d.syncAt = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Denylist) syncLoop(ctx context.Context) {
+	if err := d.sync(ctx); err != nil {
+		logger.Logger.Errorw("sync denylist", "err", err.Error())
+	}
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.sync(ctx); err != nil {
+				logger.Logger.Errorw("sync denylist", "err", err.Error())
+			}
+		}
+	}
+}
+
+// denyingStore the token store which adds what it removes to the denylist,
+// so logout, destroy, password reset and refresh reach the stateless verification
+type denyingStore struct {
+	jwts.TokenStore
+	deny *Denylist
+}
+
+// RemoveByAccess the old access token of a refresh is denied
+func (s *denyingStore) RemoveByAccess(ctx context.Context, access string) error {
+	if err := s.TokenStore.RemoveByAccess(ctx, access); err != nil {
+		return err
+	}
+	s.deny.DenyToken(ctx, access)
+	return nil
+}
+
+// RemoveByToken the session of the token is denied
+func (s *denyingStore) RemoveByToken(ctx context.Context, token string) error {
+	ti, err := s.TokenStore.GetByAccess(ctx, token)
+	if err != nil {
+		return err
+	} else if ti == nil {
+		if ti, err = s.TokenStore.GetByRefresh(ctx, token); err != nil {
+			return err
+		}
+	}
+	if err = s.TokenStore.RemoveByToken(ctx, token); err != nil {
+		return err
+	}
+	if ti != nil {
+		s.deny.DenySession(ctx, ti.GetFamilyID())
+	}
+	return nil
+}
+
+// RemoveToken every session of the user is denied
+func (s *denyingStore) RemoveToken(ctx context.Context, userID string) error {
+	infos, err := s.TokenStore.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err = s.TokenStore.RemoveToken(ctx, userID); err != nil {
+		return err
+	}
+	for _, ti := range infos {
+		s.deny.DenySession(ctx, ti.GetFamilyID())
+	}
+	return nil
+}
+
+// RemoveFamily the session is denied
+func (s *denyingStore) RemoveFamily(ctx context.Context, userID, familyID string) error {
+	if err := s.TokenStore.RemoveFamily(ctx, userID, familyID); err != nil {
+		return err
+	}
+	s.deny.DenySession(ctx, familyID)
+	return nil
+}
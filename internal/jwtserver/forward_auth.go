@@ -0,0 +1,198 @@
+package jwtserver
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+)
+
+const (
+	defaultForwardAuthQueryParam = "access_token"
+	// noForwardAuthHeader the identity header isn't emitted
+	noForwardAuthHeader = "-"
+)
+
+// the identity headers of the check endpoint
+const (
+	userIDHeader       = "User-Id"
+	userNameHeader     = "User-Name"
+	departmentIDHeader = "Department-Id"
+	tenantIDHeader     = "Tenant-Id"
+)
+
+// forwardedRequest the request the proxy authenticates
+type forwardedRequest struct {
+	method string
+	url    *url.URL
+}
+
+// newForwardedRequest the original request from the headers of traefik, caddy and nginx,
+// nginx passes them as X-Original-* by the usual auth_request config
+func newForwardedRequest(r *http.Request) *forwardedRequest {
+	method := firstHeader(r.Header, "X-Forwarded-Method", "X-Original-Method")
+	if method == "" {
+		method = r.Method
+	}
+	uri := firstHeader(r.Header, "X-Forwarded-Uri", "X-Original-URI")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+	u.Scheme = r.Header.Get("X-Forwarded-Proto")
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	u.Host = r.Header.Get("X-Forwarded-Host")
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	return &forwardedRequest{
+		method: strings.ToUpper(method),
+		url:    u,
+	}
+}
+
+func firstHeader(header http.Header, keys ...string) string {
+	for _, k := range keys {
+		if v := header.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// public whether the first rule the request matches is public, the unmatched are protected.
+// The path is cleaned before matching, a path which still has dot segments is protected.
+func (f *forwardedRequest) public(rules []configs.ForwardAuthRule) bool {
+	p, ok := cleanPath(f.url.Path)
+	if !ok {
+		return false
+	}
+	for _, rule := range rules {
+		if !matchPath(rule.Path, p) || !matchMethod(rule.Methods, f.method) {
+			continue
+		}
+		return rule.Public
+	}
+	return false
+}
+
+// cleanPath resolve the dot segments as the upstream does, so /public/../admin
+// doesn't match /public/*. It is false when a segment is still a dot segment
+// once unescaped again, e.g. the double encoded %252e%252e.
+func cleanPath(p string) (string, bool) {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	for _, seg := range strings.FieldsFunc(cleaned, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if v, err := url.PathUnescape(seg); err == nil {
+			seg = v
+		}
+		if seg == "." || seg == ".." {
+			return "", false
+		}
+	}
+	return cleaned, true
+}
+
+// matchPath a path ending with * matches by prefix
+func matchPath(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == path
+}
+
+func matchMethod(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, v := range methods {
+		if strings.EqualFold(v, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// browser a navigation of a browser is redirected to the login page, api calls get 401
+func (f *forwardedRequest) browser(r *http.Request) bool {
+	if f.method != http.MethodGet && f.method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("X-Requested-With") != "" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// ForwardAuth the forward auth endpoint of the reverse proxies. The token is read from
+// a bearer, the Access-Token header, the cookie or the query string of the original request.
+// It answers 200 with the identity headers, 302 to the login page for browsers or 401.
+func (j *jwtServer) ForwardAuth(w http.ResponseWriter, r *http.Request) error {
+	conf := j.conf.ForwardAuth
+	f := newForwardedRequest(r)
+	public := f.public(conf.Rules)
+
+	token := sessionToken(r, conf.Cookie)
+	if token == "" {
+		param := conf.QueryParam
+		if param == "" {
+			param = defaultForwardAuthQueryParam
+		}
+		token = f.url.Query().Get(param)
+	}
+	if token != "" {
+		res, err := j.CheckToken(r.Context(), r.Header.Clone(), token)
+		if err == nil {
+			setForwardAuthHeaders(w.Header(), conf.Headers, res)
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+	}
+	if public {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	if conf.LoginURL != "" && f.browser(r) {
+		loginURL, err := url.Parse(conf.LoginURL)
+		if err != nil {
+			return err
+		}
+		q := loginURL.Query()
+		q.Set("redirect", f.url.String())
+		loginURL.RawQuery = q.Encode()
+		w.Header().Set("Location", loginURL.String())
+		w.WriteHeader(http.StatusFound)
+		return nil
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	return nil
+}
+
+func setForwardAuthHeaders(header http.Header, names configs.ForwardAuthHeaders, res *CheckTokenResponse) {
+	for _, v := range []struct{ name, def, value string }{
+		{names.UserID, userIDHeader, res.UserID},
+		{names.UserName, userNameHeader, res.Name},
+		{names.DepartmentID, departmentIDHeader, res.DepID},
+		{names.TenantID, tenantIDHeader, res.TenantID},
+	} {
+		name := v.name
+		if name == "" {
+			name = v.def
+		}
+		if name == noForwardAuthHeader {
+			continue
+		}
+		header.Set(name, v.value)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis/v8"
 	error2 "github.com/quanxiang-cloud/cabin/error"
 	"github.com/quanxiang-cloud/cabin/logger"
-	"github.com/quanxiang-cloud/cabin/tailormade/client"
+
+	// postgres and sqlite drivers for the sql token store
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 
 	"github.com/quanxiang-cloud/warden/pkg/code"
 	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/device"
 	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	jwtserrors "github.com/quanxiang-cloud/warden/pkg/jwts/errors"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/generates"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/manage"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/server"
@@ -28,21 +32,54 @@ import (
 type JWTServer interface {
 	Login(ctx context.Context, r *LoginRequst) (*LoginResponse, error)
 	Logout(ctx context.Context, tokenString string) (string, error)
-	Refresh(ctx context.Context, refreshToken string) (interface{}, error)
+	Refresh(ctx context.Context, r *RefreshRequest) (interface{}, error)
 	DestroyByUserID(ctx context.Context, req *DestroyTokenRequest) (*DestroyTokenResponse, error)
 	CheckToken(c context.Context, header http.Header, token string) (response *CheckTokenResponse, err error)
+	TokenUser(c context.Context, access, refresh string) string
+	ClientID(r *http.Request) string
 	Auth(c context.Context, header http.Header, token string) (interface{}, error)
 	FaasCheck(c context.Context, req *FaasCheckReq) (*FaasCheckResp, error)
 	SwitchTenant(c context.Context, req *SwitchTenantRequest) (*SwitchTenantResponse, error)
+	JWKS(c context.Context) (*jwts.JSONWebKeySet, error)
+	Keys(c context.Context) (*KeysResponse, error)
+	RotateKey(c context.Context, req *RotateKeyRequest) (*RotateKeyResponse, error)
+	ReloadKeys(c context.Context, conf configs.JWTConfig) error
+	ListSessions(c context.Context, req *ListSessionsRequest) (*ListSessionsResponse, error)
+	RevokeSession(c context.Context, req *RevokeSessionRequest) (*RevokeSessionResponse, error)
+	Lockout(c context.Context, req *LockoutRequest) (*LockoutResponse, error)
+	ClearLockout(c context.Context, req *LockoutRequest) (*ClearLockoutResponse, error)
+	LoginMFA(ctx context.Context, r *LoginMFARequest) (*LoginResponse, error)
+	MFAStatus(c context.Context, req *MFARequest) (*MFAStatusResponse, error)
+	EnrollMFA(c context.Context, req *MFARequest) (*EnrollMFAResponse, error)
+	ActivateMFA(c context.Context, req *MFARequest) (*RecoveryCodesResponse, error)
+	DisableMFA(c context.Context, req *MFARequest) (*MFAResponse, error)
+	RegenerateRecoveryCodes(c context.Context, req *MFARequest) (*RecoveryCodesResponse, error)
+	ResetMFA(c context.Context, req *ResetMFARequest) (*MFAResponse, error)
+	Authorize(w http.ResponseWriter, r *http.Request) error
+	OAuthToken(w http.ResponseWriter, r *http.Request) error
+	Introspect(w http.ResponseWriter, r *http.Request) error
+	Revoke(w http.ResponseWriter, r *http.Request) error
+	ForwardAuth(w http.ResponseWriter, r *http.Request) error
+	Discovery(c context.Context) (*DiscoveryResponse, error)
+	LoginTypes(c context.Context) (*LoginTypesResponse, error)
+	UserInfo(c context.Context, token string) (map[string]interface{}, error)
 }
 
 //jwtServer 登录实现结构体
 type jwtServer struct {
 	s      *server.Server
-	client http.Client
+	ring   *generates.KeyRing
 	org    org.User
 	redisc redis.UniversalClient
 	conf   configs.Config
+	// deny the denylist of the stateless verification, nil when it is off
+	deny *Denylist
+	// lock the failed login counters, nil when it is off
+	lock *lockout
+	// mfa the TOTP secrets, nil when it is off
+	mfa *mfa
+	// auth the authenticators of the login types
+	auth *authenticators
 }
 
 //LoginRequst LoginRequst
@@ -50,50 +87,85 @@ type LoginRequst struct {
 	UserName  string `json:"username" binding:"required"`
 	Password  string `json:"password" binding:"required"`
 	LoginType string `json:"login_type" binding:"required"`
+	// ClientIP and UserAgent the device of the session
+	ClientIP  string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// RefreshRequest refresh the session, the device is updated
+type RefreshRequest struct {
+	RefreshToken string
+	ClientIP     string
+	UserAgent    string
 }
 
 //LoginResponse response
 type LoginResponse struct {
 	Token map[string]interface{}
-}
-
-//OrgCheckRequest 用于调用org服务
-type OrgCheckRequest struct {
-	UserName string `json:"username"` //多形态:邮箱、手机、其它
-	Password string `json:"password"`
-	Types    string `json:"types"` //登录模式
-}
-
-// OrgCheckResponse 返回用户信息
-type OrgCheckResponse struct {
-	UserID    string `json:"userID"`
-	UseStatus int    `json:"useStatus"` //状态：1正常，-2禁用，-1删除 （与账号库相同）
-	Code      int64  `json:"code"`      //错误码
-	Msg       string `json:"msg"`       //错误信息
+	// MFA the login needs the code of the authenticator, there is no token
+	MFA *MFAChallenge
 }
 
 // Login Login
 func (j *jwtServer) Login(ctx context.Context, r *LoginRequst) (*LoginResponse, error) {
-	loginReq := OrgCheckRequest{
-		UserName: r.UserName,
-		Password: r.Password,
-		Types:    r.LoginType,
+	if err := j.lock.check(ctx, r.UserName, r.ClientIP); err != nil {
+		logger.Logger.Infow("login locked", "userName", r.UserName, "clientIP", r.ClientIP)
+		return nil, err
 	}
-	userAccount := OrgCheckResponse{}
-	err := client.POST(ctx, &j.client, j.conf.OrgAPIs.Host+j.conf.OrgAPIs.LoginURI, loginReq, &userAccount)
+	userAccount, err := j.auth.authenticate(ctx, &Credentials{
+		LoginType: r.LoginType,
+		UserName:  r.UserName,
+		Password:  r.Password,
+		ClientIP:  r.ClientIP,
+	})
 	if err != nil {
 		return nil, err
 	}
 	if userAccount.UserID == "" {
+		j.lock.fail(ctx, r.UserName, r.ClientIP)
 		return nil, error2.NewErrorWithString(userAccount.Code, userAccount.Msg)
 	}
 
-	token, err := j.s.HandleTokenRequest(ctx, userAccount.UserID, nil)
+	// the failures are kept until the code is right when the user has mfa
+	challenge, err := j.loginChallenge(ctx, &mfaChallenge{
+		UserID:    userAccount.UserID,
+		UserName:  r.UserName,
+		LoginType: r.LoginType,
+	})
 	if err != nil {
+		return nil, err
+	} else if challenge != nil {
+		logger.Logger.Infow("login mfa challenge", "userID", userAccount.UserID, "clientIP", r.ClientIP)
+		return &LoginResponse{
+			MFA: challenge,
+		}, nil
+	}
+	j.lock.succeed(ctx, r.UserName)
+
+	return j.issueToken(ctx, &jwts.TokenGenerateRequest{
+		UserID:    userAccount.UserID,
+		LoginType: r.LoginType,
+		ClientIP:  r.ClientIP,
+		UserAgent: r.UserAgent,
+	})
+}
+
+// issueToken issue the tokens of the user who has logged in
+func (j *jwtServer) issueToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (*LoginResponse, error) {
+	// the tenant switched by the last session is dropped before the claims are filled
+	j.redisc.Del(ctx, wardenUserTenantCache+tgr.UserID)
+	tgr.DeviceName = device.Name(tgr.UserAgent)
+	_ = j.claims(ctx, tgr)
+
+	token, err := j.s.HandleTokenGenerateRequest(ctx, tgr)
+	if err == jwtserrors.ErrSessionLimitExceeded {
+		return nil, error2.New(code.ErrSessionLimitExceeded)
+	} else if err != nil {
 		logger.Logger.Error(err)
 		return nil, err
 	}
-	j.redisc.Del(ctx, wardenUserTenantCache+userAccount.UserID)
+	logger.Logger.Infow("login", "userID", tgr.UserID, "loginType", tgr.LoginType, "clientID", tgr.ClientID,
+		"clientIP", tgr.ClientIP, "device", tgr.DeviceName)
 	return &LoginResponse{
 		Token: token,
 	}, nil
@@ -105,11 +177,7 @@ func (j *jwtServer) Logout(c context.Context, tokenString string) (string, error
 	if tokenInfo == nil {
 		return "", errors.New("invalid accessToken")
 	}
-	basicID := j.redisc.Get(c, store.JWTRedis+tokenInfo.GetAccess()).Val()
-	err := j.s.Manager.RemoveAccessToken(c, tokenInfo.GetAccess())
-	err = j.s.Manager.RemoveRefreshToken(c, tokenInfo.GetRefresh())
-	j.redisc.Del(c, store.JWTRedis+basicID)
-	j.redisc.HDel(c, store.JWTRedisUsers+tokenInfo.GetUserID(), basicID)
+	err := j.s.Manager.RevokeToken(c, tokenInfo.GetAccess(), "")
 	if err != nil {
 		return "", err
 	}
@@ -117,8 +185,13 @@ func (j *jwtServer) Logout(c context.Context, tokenString string) (string, error
 }
 
 // Refresh Refresh
-func (j *jwtServer) Refresh(ctx context.Context, refreshToken string) (interface{}, error) {
-	token, err := j.s.HandleRefreshTokenRequest(ctx, refreshToken)
+func (j *jwtServer) Refresh(ctx context.Context, r *RefreshRequest) (interface{}, error) {
+	token, err := j.s.HandleRefreshTokenGenerateRequest(ctx, &jwts.TokenGenerateRequest{
+		Refresh:    r.RefreshToken,
+		ClientIP:   r.ClientIP,
+		UserAgent:  r.UserAgent,
+		DeviceName: device.Name(r.UserAgent),
+	})
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil, error2.New(code.ErrInvalidRefreshToken)
@@ -151,8 +224,13 @@ type CheckTokenResponse struct {
 
 // CheckToken CheckToken
 func (j *jwtServer) CheckToken(c context.Context, header http.Header, accesstoken string) (response *CheckTokenResponse, err error) {
-	var tokenInfo jwts.TokenInfo
+	if j.deny != nil && j.deny.Fresh() {
+		if res, ok := j.checkStateless(c, header, accesstoken); ok {
+			return res, nil
+		}
+	}
 
+	var tokenInfo jwts.TokenInfo
 	tokenInfo, err = j.s.ValidationBearerToken(c, "", accesstoken)
 	if err != nil {
 		return nil, error2.New(code.ErrInvalidAccessToken)
@@ -170,6 +248,56 @@ func (j *jwtServer) CheckToken(c context.Context, header http.Header, accesstoke
 	return res, nil
 }
 
+// TokenUser the user of the access or the refresh token, empty when neither is valid.
+// The access token is verified by its signature only, so it is cheap for every request.
+func (j *jwtServer) TokenUser(c context.Context, access, refresh string) string {
+	if access != "" {
+		if claims, err := generates.ParseClaims(j.ring.Verify(c, access)); err == nil {
+			return claims.UserID()
+		}
+	}
+	if refresh != "" {
+		if ti, err := j.s.Manager.LoadRefreshToken(c, refresh); err == nil {
+			return ti.GetUserID()
+		}
+	}
+	return ""
+}
+
+// ClientID the client of the request when its credentials are verified, empty otherwise
+func (j *jwtServer) ClientID(r *http.Request) string {
+	return j.s.VerifiedClientID(r)
+}
+
+// checkStateless verify the token by the signature, exp and the denylist, the profile comes from the claims.
+// It isn't ok for the tokens the claims can't tell, they are checked by the token store,
+// nor when the user has switched to another tenant than the one of the claims.
+func (j *jwtServer) checkStateless(c context.Context, header http.Header, accesstoken string) (*CheckTokenResponse, bool) {
+	claims, err := generates.ParseClaims(j.ring.Verify(c, accesstoken))
+	if err != nil || claims.IsLegacy() || claims.UserID() == "" || claims.SessionID == "" {
+		return nil, false
+	}
+	if j.deny.Denied(claims.Id, claims.SessionID) {
+		return nil, false
+	}
+	if claims.Name == "" && claims.TenantID == "" {
+		// the org service failed when the token was issued
+		return nil, false
+	}
+	// SwitchTenant only changes the tenant cache which GetUserInfo reads
+	if tenantID, err := j.redisc.Get(c, wardenUserTenantCache+claims.UserID()).Result(); err != nil && err != redis.Nil {
+		return nil, false
+	} else if tenantID != "" && tenantID != claims.TenantID {
+		return nil, false
+	}
+	return &CheckTokenResponse{
+		UserID:   claims.UserID(),
+		Name:     claims.Name,
+		DepID:    claims.DepartmentID(),
+		TenantID: claims.TenantID,
+	}, true
+}
+
 // Auth Auth
 func (j *jwtServer) Auth(c context.Context, header http.Header, token string) (interface{}, error) {
 	verifyToken, err := j.s.Manager.VerifyToken(c, token)
@@ -177,15 +305,19 @@ func (j *jwtServer) Auth(c context.Context, header http.Header, token string) (i
 		return nil, error2.New(code.ErrInvalidAccessToken)
 	}
 
-	info, depID, err := GetUserInfo(c, j.org, j.redisc, header, verifyToken["jti"].(string), j.conf)
+	claims, err := generates.ParseClaims(verifyToken)
 	if err != nil {
 		return nil, error2.New(code.ErrInvalidAccessToken)
 	}
-	other := make(map[string]string)
-	other["Department-Id"] = depID
-	other["User-Name"] = info.Name
 
-	ti, errData := j.s.HandleTokenRequest(c, info.ID, other)
+	tgr := &jwts.TokenGenerateRequest{
+		UserID: claims.UserID(),
+	}
+	if err = j.fillProfile(c, header, tgr); err != nil {
+		return nil, error2.New(code.ErrInvalidAccessToken)
+	}
+
+	ti, errData := j.s.HandleTokenGenerateRequest(c, tgr)
 	if errData != nil {
 		logger.Logger.Error(errData)
 		return nil, error2.New(code.ErrInvalidAccessToken)
@@ -193,6 +325,22 @@ func (j *jwtServer) Auth(c context.Context, header http.Header, token string) (i
 	return ti, nil
 }
 
+// fillProfile fill the tenant, name and departments claims of the user
+func (j *jwtServer) fillProfile(c context.Context, header http.Header, tgr *jwts.TokenGenerateRequest) error {
+	info, depID, err := GetUserInfo(c, j.org, j.redisc, header, tgr.UserID, j.conf)
+	if err != nil {
+		return err
+	}
+	tgr.TenantID = info.TenantID
+	tgr.UserName = info.Name
+	tgr.DepartmentIDs = GetUserDEPIDs(info.Dep)
+	tgr.OtherInfo = map[string]string{
+		"Department-Id": depID,
+		"User-Name":     info.Name,
+	}
+	return nil
+}
+
 // FaasCheckReq FaasCheckReq
 type FaasCheckReq struct {
 	Token string
@@ -216,35 +364,132 @@ func (j *jwtServer) FaasCheck(c context.Context, req *FaasCheckReq) (*FaasCheckR
 }
 
 //NewJWTImpl 初始化
-func NewJWTImpl(conf configs.Config, redisClient redis.UniversalClient) (JWTServer, error) {
-
-	return &jwtServer{
-		s:      NewServer(),
-		client: client.New(configs.GetConfig().InternalNet),
+func NewJWTImpl(ctx context.Context, conf configs.Config, s *server.Server, ring *generates.KeyRing, deny *Denylist, redisClient redis.UniversalClient) (JWTServer, error) {
+	j := &jwtServer{
+		s:      s,
+		ring:   ring,
 		org:    org.NewUser(configs.GetConfig().InternalNet),
 		redisc: redisClient,
 		conf:   conf,
-	}, nil
+		deny:   deny,
+		lock:   newLockout(conf.Lockout, redisClient),
+	}
+	proxies, err := device.ParseProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if j.mfa, err = newMFA(conf.MFA, redisClient); err != nil {
+		return nil, err
+	}
+	if j.auth, err = newAuthenticators(conf); err != nil {
+		return nil, err
+	}
+	s.ClientIPHandler = proxies.ClientIP
+	s.PasswordAuthorizationHandler = j.passwordAuthorization
+	s.ClaimsHandler = j.claims
+	s.UserAuthorizationHandler = j.userAuthorization
+	s.InternalErrorHandler = oauthInternalError
+	s.IntrospectionHandler = j.introspection
+	s.ExtensionFieldsHandler = j.tokenFields
+	if err := j.loadKeys(ctx, conf.JWTConfig); err != nil {
+		return nil, err
+	}
+	if !j.oidc() {
+		logger.Logger.Warnw("oidc is off, the id tokens need an asymmetric current key", "kid", ring.Current())
+	}
+	go j.syncKeysLoop(ctx, conf.JWTConfig.KeyRing.SyncInterval*time.Second)
+	if deny != nil {
+		go deny.syncLoop(ctx)
+	}
+	return j, nil
 }
 
-//NewServer 初始化
-func NewServer() *server.Server {
+//NewServer 初始化, the removed tokens are added to the denylist when it isn't nil
+func NewServer(gen jwts.AccessGenerate, deny *Denylist) *server.Server {
+	conf := configs.GetConfig().JWTConfig
 	manager := manage.NewDefaultManager()
 	config := new(manage.Config)
-	config.AccessTokenExp = time.Hour * configs.GetConfig().JWTConfig.AccessTokenExp
-	config.RefreshTokenExp = time.Hour * configs.GetConfig().JWTConfig.RefreshTokenExp
+	config.AccessTokenExp = time.Hour * conf.AccessTokenExp
+	config.RefreshTokenExp = time.Hour * conf.RefreshTokenExp
 	config.IsGenerateRefresh = true
+	manager.SetTokenConfig(config)
+
+	// a zero lifetime keeps the lifetime the token was issued with
+	manager.SetRefreshTokenConfig(&manage.RefreshingConfig{
+		IsGenerateRefresh:  true,
+		IsResetRefreshTime: conf.ResetRefreshTime,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
+	})
+	for loginType, v := range conf.LoginTypes {
+		manager.SetLoginTypeTokenConfig(loginType, tokenLifetime(v))
+	}
+	for clientID, v := range conf.Clients {
+		manager.SetClientTokenConfig(clientID, tokenLifetime(v))
+	}
+	manager.SetSessionLimit(sessionLimit(conf.SessionLimit))
+	for tenantID, v := range conf.TenantSessionLimits {
+		manager.SetTenantSessionLimit(tenantID, sessionLimit(v))
+	}
 
-	manager.MapTokenStorage(store.NewRedisClusterStore(&redis.ClusterOptions{
-		Addrs:    configs.GetConfig().Redis.Addrs,
-		Username: configs.GetConfig().Redis.Username,
-		Password: configs.GetConfig().Redis.Password,
-	}))
+	tokenStore := newTokenStore(configs.GetConfig())
+	if deny != nil {
+		tokenStore = &denyingStore{TokenStore: tokenStore, deny: deny}
+	}
+	manager.MapTokenStorage(tokenStore)
+	manager.MapClientStorage(newClientStore(configs.GetConfig().OAuth))
+	if exp := configs.GetConfig().OAuth.CodeExp; exp > 0 {
+		manager.SetCodeExp(exp * time.Second)
+	}
 
 	// generate jwtServer access token
-	manager.MapAccessGenerate(generates.NewJWTAccessGenerate("", []byte(configs.GetConfig().JWTConfig.JwtKey), nil, jwt.SigningMethodHS256))
+	manager.MapAccessGenerate(gen)
+	manager.SetEventHandler(securityEvent)
 
-	return server.NewServer(server.NewConfig(), manager)
+	srvConfig := server.NewConfig()
+	srvConfig.ForcePKCE = configs.GetConfig().OAuth.ForcePKCE
+	return server.NewServer(srvConfig, manager)
+}
+
+// securityEvent log the security events of the token management
+func securityEvent(ctx context.Context, e *jwts.Event) {
+	logger.Logger.Warnw("security event", "type", e.Type, "userID", e.UserID, "familyID", e.FamilyID, "time", e.Time)
+}
+
+func sessionLimit(v configs.SessionLimit) *manage.SessionLimit {
+	return &manage.SessionLimit{
+		MaxSessions:  v.MaxSessions,
+		Policy:       manage.SessionLimitPolicy(v.Policy),
+		PerLoginType: v.PerLoginType,
+	}
+}
+
+func tokenLifetime(v configs.TokenLifetime) *manage.Config {
+	return &manage.Config{
+		AccessTokenExp:    time.Hour * v.AccessTokenExp,
+		RefreshTokenExp:   time.Hour * v.RefreshTokenExp,
+		IsGenerateRefresh: true,
+	}
+}
+
+func newTokenStore(conf *configs.Config) jwts.TokenStore {
+	switch conf.JWTConfig.TokenStore.Type {
+	case configs.TokenStoreMemory:
+		return store.NewMemoryTokenStoreWithInterval(conf.JWTConfig.TokenStore.SweepInterval * time.Second)
+	case configs.TokenStoreSQL:
+		c := conf.JWTConfig.TokenStore
+		s, err := store.OpenSQLTokenStore(c.Driver, c.DSN, c.SweepInterval*time.Second)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	default:
+		return store.NewRedisClusterStore(&redis.ClusterOptions{
+			Addrs:    conf.Redis.Addrs,
+			Username: conf.Redis.Username,
+			Password: conf.Redis.Password,
+		})
+	}
 }
 
 const wardenUserCache = "warden:orgs:user:"
@@ -341,7 +586,24 @@ type SwitchTenantResponse struct {
 // SwitchTenant SwitchTenant
 func (j *jwtServer) SwitchTenant(c context.Context, r *SwitchTenantRequest) (*SwitchTenantResponse, error) {
 	//todo 这里要到租户服务验证人员和租户关系是否存在，存在就替换缓存
-	token, _ := j.s.Manager.LoadAccessToken(c, r.Token)
+	token, err := j.s.Manager.LoadAccessToken(c, r.Token)
+	if err != nil {
+		return nil, error2.New(code.ErrInvalidAccessToken)
+	}
 	j.redisc.SetEX(c, wardenUserTenantCache+token.GetUserID(), r.TenantID, j.conf.JWTConfig.AccessTokenExp*time.Minute)
 	return nil, nil
 }
+
+// JWKS the public keys of the access token generate
+func (j *jwtServer) JWKS(c context.Context) (*jwts.JSONWebKeySet, error) {
+	keys, err := j.s.Manager.PublicKeys(c)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []jwts.JSONWebKey{}
+	}
+	return &jwts.JSONWebKeySet{
+		Keys: keys,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package jwtserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis/v8"
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/generates"
+)
+
+// the rotation state shared by the cluster, the key material stays in the config
+const (
+	// wardenKeyRingCurrent id of the current key
+	wardenKeyRingCurrent = "warden:keyring:current"
+	// wardenKeyRingConfig id of the current key in the config last loaded
+	wardenKeyRingConfig = "warden:keyring:config"
+	// wardenKeyRingRetire key id -> unix time of the retirement
+	wardenKeyRingRetire = "warden:keyring:retire"
+
+	defaultKeySyncInterval = 30 * time.Second
+)
+
+// NewKeyRing build the signing key ring from the config
+func NewKeyRing(conf configs.JWTConfig) (*generates.KeyRing, error) {
+	current, keys, err := signingKeys(conf)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := generates.NewKeyRing(current, conf.KeyRing.GracePeriod*time.Hour, keys...)
+	if err != nil {
+		return nil, err
+	}
+	ring.ClaimsOptions = generates.ClaimsOptions{
+		Issuer:       conf.Claims.Issuer,
+		Audience:     conf.Claims.Audience,
+		AcceptLegacy: conf.Claims.AcceptLegacy,
+	}
+	return ring, nil
+}
+
+// signingKeys the keys of the ring, the single key config is a ring of one key
+func signingKeys(conf configs.JWTConfig) (string, []*generates.SigningKey, error) {
+	if len(conf.KeyRing.Keys) == 0 {
+		key, err := signingKey(configs.SigningKey{
+			ID:             conf.KeyID,
+			SigningMethod:  conf.SigningMethod,
+			Key:            conf.JwtKey,
+			PrivateKeyFile: conf.PrivateKeyFile,
+			PublicKeyFile:  conf.PublicKeyFile,
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		return conf.KeyID, []*generates.SigningKey{key}, nil
+	}
+
+	keys := make([]*generates.SigningKey, 0, len(conf.KeyRing.Keys))
+	for _, v := range conf.KeyRing.Keys {
+		key, err := signingKey(v)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, key)
+	}
+	return conf.KeyRing.Current, keys, nil
+}
+
+func signingKey(conf configs.SigningKey) (*generates.SigningKey, error) {
+	key := &generates.SigningKey{
+		ID:     conf.ID,
+		Method: jwt.SigningMethodHS256,
+		Key:    []byte(conf.Key),
+	}
+	if conf.SigningMethod != "" {
+		key.Method = jwt.GetSigningMethod(conf.SigningMethod)
+		if key.Method == nil {
+			return nil, errors.New("unsupported signing method " + conf.SigningMethod)
+		}
+	}
+	if conf.RetireAt != "" {
+		retireAt, err := time.Parse(time.RFC3339, conf.RetireAt)
+		if err != nil {
+			return nil, err
+		}
+		key.RetireAt = retireAt
+	}
+	if strings.HasPrefix(key.Method.Alg(), "HS") {
+		return key, nil
+	}
+
+	var err error
+	if key.Key, err = ioutil.ReadFile(conf.PrivateKeyFile); err != nil {
+		return nil, err
+	}
+	if conf.PublicKeyFile != "" {
+		if key.PubKey, err = ioutil.ReadFile(conf.PublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// loadKeys load the key material of the config, a current key changed in the
+// config is published to the cluster, otherwise the cluster state wins
+func (j *jwtServer) loadKeys(ctx context.Context, conf configs.JWTConfig) error {
+	current, keys, err := signingKeys(conf)
+	if err != nil {
+		return err
+	}
+	previous := j.ring.Current()
+	if err = j.ring.Load(current, keys...); err != nil {
+		return err
+	}
+
+	published, err := j.redisc.Get(ctx, wardenKeyRingConfig).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if published != current {
+		retireAt := time.Now().Add(conf.KeyRing.GracePeriod * time.Hour)
+		if err = j.publishRotation(ctx, previous, current, retireAt); err != nil {
+			return err
+		}
+		j.redisc.Set(ctx, wardenKeyRingConfig, current, 0)
+		return nil
+	}
+	return j.syncKeys(ctx)
+}
+
+// publishRotation share the rotation with the cluster, the first retirement time wins
+func (j *jwtServer) publishRotation(ctx context.Context, previous, current string, retireAt time.Time) error {
+	pipe := j.redisc.TxPipeline()
+	pipe.Set(ctx, wardenKeyRingCurrent, current, 0)
+	if previous != current {
+		pipe.HSetNX(ctx, wardenKeyRingRetire, previous, retireAt.Unix())
+	}
+	pipe.HDel(ctx, wardenKeyRingRetire, current)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// syncKeys apply the rotation state of the cluster to the ring
+func (j *jwtServer) syncKeys(ctx context.Context) error {
+	current, err := j.redisc.Get(ctx, wardenKeyRingCurrent).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if current != "" && current != j.ring.Current() {
+		if _, err = j.ring.Rotate(current); err != nil {
+			logger.Logger.Errorw("sync signing key", "kid", current, "err", err.Error())
+		}
+	}
+
+	retires, err := j.redisc.HGetAll(ctx, wardenKeyRingRetire).Result()
+	if err != nil {
+		return err
+	}
+	for id, v := range retires {
+		at, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		// keys of the cluster which this instance doesn't know are ignored
+		_ = j.ring.Retire(id, time.Unix(at, 0))
+	}
+	return nil
+}
+
+func (j *jwtServer) syncKeysLoop(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultKeySyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.syncKeys(ctx); err != nil {
+				logger.Logger.Errorw("sync signing keys", "err", err.Error())
+			}
+		}
+	}
+}
+
+// KeysResponse the signing keys
+type KeysResponse struct {
+	Current string                `json:"current"`
+	Keys    []generates.KeyStatus `json:"keys"`
+}
+
+// Keys list the signing keys without the key material
+func (j *jwtServer) Keys(c context.Context) (*KeysResponse, error) {
+	return &KeysResponse{
+		Current: j.ring.Current(),
+		Keys:    j.ring.Keys(),
+	}, nil
+}
+
+// RotateKeyRequest rotate to a key of the ring
+type RotateKeyRequest struct {
+	KeyID string `json:"kid" binding:"required"`
+}
+
+// RotateKeyResponse rotate key response
+type RotateKeyResponse struct {
+	Previous string     `json:"previous"`
+	Current  string     `json:"current"`
+	RetireAt *time.Time `json:"retireAt,omitempty"`
+}
+
+// RotateKey make a configured key current, the previous key keeps verifying for the grace period
+func (j *jwtServer) RotateKey(c context.Context, req *RotateKeyRequest) (*RotateKeyResponse, error) {
+	previous := j.ring.Current()
+	retireAt, err := j.ring.Rotate(req.KeyID)
+	if err != nil {
+		return nil, error2.New(code.ErrInvalidSigningKey)
+	}
+	res := &RotateKeyResponse{
+		Previous: previous,
+		Current:  req.KeyID,
+	}
+	if previous == req.KeyID {
+		return res, nil
+	}
+	if err = j.publishRotation(c, previous, req.KeyID, retireAt); err != nil {
+		return nil, err
+	}
+	res.RetireAt = &retireAt
+	return res, nil
+}
+
+// ReloadKeys reload the key material after the config changed
+func (j *jwtServer) ReloadKeys(c context.Context, conf configs.JWTConfig) error {
+	return j.loadKeys(c, conf)
+}
@@ -0,0 +1,283 @@
+package jwtserver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+)
+
+const (
+	// wardenLockoutFailures "user:<username>" and "ip:<ip>" -> failed logins within the window
+	wardenLockoutFailures = "warden:lockout:failures:"
+	// wardenLockoutLocked "user:<username>" and "ip:<ip>" -> locked until the key expires
+	wardenLockoutLocked = "warden:lockout:locked:"
+
+	lockoutUser = "user:"
+	lockoutIP   = "ip:"
+
+	defaultLockoutUserThreshold = 5
+	defaultLockoutIPThreshold   = 50
+	defaultLockoutWindow        = 15 * time.Minute
+	defaultLockoutDuration      = 15 * time.Minute
+	defaultLockoutDelayAfter    = 3
+	defaultLockoutMaxDelay      = 8 * time.Second
+)
+
+var errAccountLocked = error2.New(code.ErrAccountLocked)
+
+// countFailure count a failure or an attempt, the window starts by the first one.
+// The expiry is set by the same call, so a counter never lives without it.
+var countFailure = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// lockout the failed logins are counted by username and by client ip,
+// the logins are delayed progressively and locked when the counters reach the thresholds.
+// Redis errors don't block the logins, the org service still checks the password.
+type lockout struct {
+	redisc redis.UniversalClient
+
+	userThreshold int64
+	ipThreshold   int64
+	window        time.Duration
+	duration      time.Duration
+	delayAfter    int64
+	maxDelay      time.Duration
+}
+
+// newLockout nil when it is off
+func newLockout(conf configs.Lockout, redisc redis.UniversalClient) *lockout {
+	if !conf.Enable {
+		return nil
+	}
+	l := &lockout{
+		redisc:        redisc,
+		userThreshold: conf.UserThreshold,
+		ipThreshold:   conf.IPThreshold,
+		window:        conf.Window * time.Second,
+		duration:      conf.LockDuration * time.Second,
+		delayAfter:    conf.DelayAfter,
+		maxDelay:      conf.MaxDelay * time.Second,
+	}
+	if l.userThreshold <= 0 {
+		l.userThreshold = defaultLockoutUserThreshold
+	}
+	if l.ipThreshold <= 0 {
+		l.ipThreshold = defaultLockoutIPThreshold
+	}
+	if l.window <= 0 {
+		l.window = defaultLockoutWindow
+	}
+	if l.duration <= 0 {
+		l.duration = defaultLockoutDuration
+	}
+	if l.delayAfter <= 0 {
+		l.delayAfter = defaultLockoutDelayAfter
+	}
+	if l.maxDelay <= 0 {
+		l.maxDelay = defaultLockoutMaxDelay
+	}
+	return l
+}
+
+// lockoutKeys the counter ids of the login, usernames aren't case sensitive
+func lockoutKeys(username, ip string) []string {
+	keys := make([]string, 0, 2)
+	if username = strings.ToLower(strings.TrimSpace(username)); username != "" {
+		keys = append(keys, lockoutUser+username)
+	}
+	if ip != "" {
+		keys = append(keys, lockoutIP+ip)
+	}
+	return keys
+}
+
+// check the login is refused when the username or the ip is locked,
+// it is delayed when the username has failed more than delayAfter times
+func (l *lockout) check(ctx context.Context, username, ip string) error {
+	if l == nil {
+		return nil
+	}
+	keys := lockoutKeys(username, ip)
+	for _, k := range keys {
+		n, err := l.redisc.Exists(ctx, wardenLockoutLocked+k).Result()
+		if err != nil {
+			logger.Logger.Errorw("check lockout", "key", k, "err", err.Error())
+			return nil
+		}
+		if n > 0 {
+			return errAccountLocked
+		}
+	}
+	if len(keys) == 0 || !strings.HasPrefix(keys[0], lockoutUser) {
+		return nil
+	}
+
+	failures, err := l.redisc.Get(ctx, wardenLockoutFailures+keys[0]).Int64()
+	if err != nil {
+		return nil
+	}
+	delay := l.delay(failures)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delay 1s after delayAfter failures, doubled by every failure up to maxDelay
+func (l *lockout) delay(failures int64) time.Duration {
+	n := failures - l.delayAfter
+	if n <= 0 {
+		return 0
+	}
+	delay := time.Second
+	for i := int64(1); i < n && delay < l.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.maxDelay {
+		delay = l.maxDelay
+	}
+	return delay
+}
+
+// fail count the failed login, the username or the ip is locked when it reaches the threshold
+func (l *lockout) fail(ctx context.Context, username, ip string) {
+	if l == nil {
+		return
+	}
+	for _, k := range lockoutKeys(username, ip) {
+		n, err := countFailure.Run(ctx, l.redisc, []string{wardenLockoutFailures + k}, l.window.Milliseconds()).Int64()
+		if err != nil {
+			logger.Logger.Errorw("count failed login", "key", k, "err", err.Error())
+			continue
+		}
+		threshold := l.userThreshold
+		if strings.HasPrefix(k, lockoutIP) {
+			threshold = l.ipThreshold
+		}
+		if n < threshold {
+			continue
+		}
+		if err = l.redisc.Set(ctx, wardenLockoutLocked+k, n, l.duration).Err(); err != nil {
+			logger.Logger.Errorw("lock login", "key", k, "err", err.Error())
+			continue
+		}
+		l.redisc.Del(ctx, wardenLockoutFailures+k)
+		logger.Logger.Warnw("login locked", "key", k, "failures", n, "duration", l.duration.String())
+	}
+}
+
+// succeed the failures of the username are forgotten, the ones of the ip are kept
+func (l *lockout) succeed(ctx context.Context, username string) {
+	if l == nil {
+		return
+	}
+	for _, k := range lockoutKeys(username, "") {
+		l.redisc.Del(ctx, wardenLockoutFailures+k)
+	}
+}
+
+// LockoutRequest the lock state of the username or the client ip
+type LockoutRequest struct {
+	UserName string `json:"userName" form:"userName"`
+	IP       string `json:"ip" form:"ip"`
+}
+
+// LockState the failed logins and the lock of a username or a client ip
+type LockState struct {
+	Failures    int64      `json:"failures"`
+	Locked      bool       `json:"locked"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// LockoutResponse lock state response
+type LockoutResponse struct {
+	User *LockState `json:"user,omitempty"`
+	IP   *LockState `json:"ip,omitempty"`
+}
+
+// ClearLockoutResponse clear lock state response
+type ClearLockoutResponse struct {
+}
+
+// Lockout the lock state of the username and the client ip
+func (j *jwtServer) Lockout(c context.Context, req *LockoutRequest) (*LockoutResponse, error) {
+	if req.UserName == "" && req.IP == "" {
+		return nil, error2.New(code.InvalidParams)
+	}
+	res := &LockoutResponse{}
+	for _, k := range lockoutKeys(req.UserName, req.IP) {
+		state, err := j.lockState(c, k)
+		if err != nil {
+			logger.Logger.Errorw("get lock state", "key", k, "err", err.Error())
+			return nil, err
+		}
+		if strings.HasPrefix(k, lockoutUser) {
+			res.User = state
+		} else {
+			res.IP = state
+		}
+	}
+	return res, nil
+}
+
+func (j *jwtServer) lockState(c context.Context, key string) (*LockState, error) {
+	state := &LockState{}
+	failures, err := j.redisc.Get(c, wardenLockoutFailures+key).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	state.Failures = failures
+
+	ttl, err := j.redisc.TTL(c, wardenLockoutLocked+key).Result()
+	if err != nil {
+		return nil, err
+	}
+	// -2 the key doesn't exist, -1 it never expires
+	if ttl == -2 {
+		return state, nil
+	}
+	state.Locked = true
+	if ttl > 0 {
+		until := time.Now().Add(ttl)
+		state.LockedUntil = &until
+	}
+	return state, nil
+}
+
+// ClearLockout clear the failed logins and the lock of the username and the client ip
+func (j *jwtServer) ClearLockout(c context.Context, req *LockoutRequest) (*ClearLockoutResponse, error) {
+	keys := lockoutKeys(req.UserName, req.IP)
+	if len(keys) == 0 {
+		return nil, error2.New(code.InvalidParams)
+	}
+	// the keys are apart in a cluster
+	for _, k := range keys {
+		for _, key := range []string{wardenLockoutFailures + k, wardenLockoutLocked + k} {
+			if err := j.redisc.Del(c, key).Err(); err != nil {
+				logger.Logger.Errorw("clear lockout", "key", key, "err", err.Error())
+				return nil, err
+			}
+		}
+	}
+	logger.Logger.Infow("lockout cleared", "userName", req.UserName, "ip", req.IP)
+	return &ClearLockoutResponse{}, nil
+}
@@ -0,0 +1,461 @@
+package jwtserver
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/totp"
+)
+
+const (
+	// wardenMFASecret user id -> the sealed secret of the enabled mfa
+	wardenMFASecret = "warden:mfa:secret:"
+	// wardenMFAPending user id -> the sealed secret waiting for the first code
+	wardenMFAPending = "warden:mfa:pending:"
+	// wardenMFAUsed user id:time step -> the code of the step has been used
+	wardenMFAUsed = "warden:mfa:used:"
+	// wardenMFAChallenge challenge id -> the login waiting for the code
+	wardenMFAChallenge = "warden:mfa:challenge:"
+	// wardenMFAAttempts challenge id -> the wrong codes of the challenge
+	wardenMFAAttempts = "warden:mfa:attempts:"
+
+	defaultMFAIssuer       = "warden"
+	defaultMFAChallengeExp = 5 * time.Minute
+	mfaEnrollExp           = 10 * time.Minute
+	// mfaSkew the codes of the previous and the next step are accepted for the clock drift
+	mfaSkew = 1
+	// mfaMaxAttempts the challenge is dropped after the wrong codes
+	mfaMaxAttempts = 5
+)
+
+var errMFARequired = errors.New("mfa required")
+
+// mfa the TOTP secrets sealed by AES-GCM in redis, the user id is the additional data
+// so a sealed secret can't be moved to another user
+type mfa struct {
+	redisc       redis.UniversalClient
+	aead         cipher.AEAD
+	issuer       string
+	challengeExp time.Duration
+}
+
+// newMFA nil when it is off
+func newMFA(conf configs.MFA, redisc redis.UniversalClient) (*mfa, error) {
+	if !conf.Enable {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("mfa encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("mfa encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	m := &mfa{
+		redisc:       redisc,
+		aead:         aead,
+		issuer:       conf.Issuer,
+		challengeExp: conf.ChallengeExp * time.Second,
+	}
+	if m.issuer == "" {
+		m.issuer = defaultMFAIssuer
+	}
+	if m.challengeExp <= 0 {
+		m.challengeExp = defaultMFAChallengeExp
+	}
+	return m, nil
+}
+
+func (m *mfa) seal(userID, secret string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(secret), []byte(userID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (m *mfa) open(userID, sealed string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	size := m.aead.NonceSize()
+	if len(buf) < size {
+		return "", errors.New("sealed secret is too short")
+	}
+	secret, err := m.aead.Open(nil, buf[:size], buf[size:], []byte(userID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// secret the secret of the key, empty when there is none
+func (m *mfa) secret(ctx context.Context, key, userID string) (string, error) {
+	sealed, err := m.redisc.Get(ctx, key+userID).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return m.open(userID, sealed)
+}
+
+// enabled whether the login of the user needs a code, it is false when mfa is off
+func (m *mfa) enabled(ctx context.Context, userID string) (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+	n, err := m.redisc.Exists(ctx, wardenMFASecret+userID).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// verify the code of the secret, a code is used once only
+func (m *mfa) verify(ctx context.Context, userID, secret, code string) (bool, error) {
+	step, ok := totp.Validate(secret, code, time.Now(), mfaSkew)
+	if !ok {
+		return false, nil
+	}
+	ttl := time.Duration(totp.Period*(2*mfaSkew+1)) * time.Second
+	return m.redisc.SetNX(ctx, fmt.Sprintf("%s%s:%d", wardenMFAUsed, userID, step), 1, ttl).Result()
+}
+
+// mfaChallenge the login which passed the password and waits for the code
+type mfaChallenge struct {
+	UserID    string `json:"userID"`
+	UserName  string `json:"userName"`
+	LoginType string `json:"loginType"`
+}
+
+func (m *mfa) newChallenge(ctx context.Context, ch *mfaChallenge) (string, error) {
+	buf, err := json.Marshal(ch)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	if err = m.redisc.Set(ctx, wardenMFAChallenge+id, buf, m.challengeExp).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// challenge nil when it doesn't exist or has expired
+func (m *mfa) challenge(ctx context.Context, id string) (*mfaChallenge, error) {
+	buf, err := m.redisc.Get(ctx, wardenMFAChallenge+id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ch := &mfaChallenge{}
+	if err = json.Unmarshal(buf, ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// attempt count the attempt of the challenge before its code is verified,
+// so the parallel attempts can't pass mfaMaxAttempts
+func (m *mfa) attempt(ctx context.Context, id string) (int64, error) {
+	return countFailure.Run(ctx, m.redisc, []string{wardenMFAAttempts + id}, m.challengeExp.Milliseconds()).Int64()
+}
+
+// takeChallenge the challenge is removed by the login it is used for,
+// it is false when another login has used it
+func (m *mfa) takeChallenge(ctx context.Context, id string) (bool, error) {
+	n, err := m.redisc.Del(ctx, wardenMFAChallenge+id).Result()
+	if err != nil {
+		return false, err
+	}
+	m.redisc.Del(ctx, wardenMFAAttempts+id)
+	return n == 1, nil
+}
+
+// removeChallenge the keys are apart in a cluster
+func (m *mfa) removeChallenge(ctx context.Context, id string) {
+	m.redisc.Del(ctx, wardenMFAChallenge+id)
+	m.redisc.Del(ctx, wardenMFAAttempts+id)
+}
+
+// MFAChallenge the login needs the code of the authenticator,
+// the challenge and the code are posted to /login/mfa
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	Challenge   string `json:"mfa_challenge"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// LoginMFARequest exchange the challenge and the code for the tokens
+type LoginMFARequest struct {
+	Challenge string `json:"mfa_challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+	// ClientIP and UserAgent the device of the session
+	ClientIP  string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// MFARequest the mfa of the user of the token, the code confirms the change
+type MFARequest struct {
+	Code     string `json:"code"`
+	Token    string `json:"-"`
+	ClientIP string `json:"-"`
+}
+
+// MFAStatusResponse mfa status response
+type MFAStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	// RecoveryCodes the unused recovery codes
+	RecoveryCodes int64          `json:"recoveryCodes"`
+	RecoveryUses  []*RecoveryUse `json:"recoveryUses"`
+}
+
+// EnrollMFAResponse the secret to add to the authenticator, the uri is shown as a QR code
+type EnrollMFAResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFAResponse mfa response
+type MFAResponse struct {
+}
+
+// loginChallenge the challenge of the user whose login needs a code, nil for the others
+func (j *jwtServer) loginChallenge(ctx context.Context, ch *mfaChallenge) (*MFAChallenge, error) {
+	enabled, err := j.mfa.enabled(ctx, ch.UserID)
+	if err != nil {
+		logger.Logger.Errorw("check mfa", "userID", ch.UserID, "err", err.Error())
+		return nil, err
+	} else if !enabled {
+		return nil, nil
+	}
+	id, err := j.mfa.newChallenge(ctx, ch)
+	if err != nil {
+		logger.Logger.Errorw("new mfa challenge", "userID", ch.UserID, "err", err.Error())
+		return nil, err
+	}
+	return &MFAChallenge{
+		MFARequired: true,
+		Challenge:   id,
+		ExpiresIn:   int64(j.mfa.challengeExp / time.Second),
+	}, nil
+}
+
+// LoginMFA the tokens of the challenge are issued when the code is right
+func (j *jwtServer) LoginMFA(ctx context.Context, r *LoginMFARequest) (*LoginResponse, error) {
+	if j.mfa == nil {
+		return nil, error2.New(code.ErrMFADisabled)
+	}
+	ch, err := j.mfa.challenge(ctx, r.Challenge)
+	if err != nil {
+		logger.Logger.Errorw("get mfa challenge", "err", err.Error())
+		return nil, err
+	} else if ch == nil {
+		return nil, error2.New(code.ErrInvalidMFAChallenge)
+	}
+	if err = j.lock.check(ctx, ch.UserName, r.ClientIP); err != nil {
+		return nil, err
+	}
+	attempts, err := j.mfa.attempt(ctx, r.Challenge)
+	if err != nil {
+		logger.Logger.Errorw("count mfa attempts", "err", err.Error())
+		return nil, err
+	} else if attempts > mfaMaxAttempts {
+		j.mfa.removeChallenge(ctx, r.Challenge)
+		return nil, error2.New(code.ErrInvalidMFAChallenge)
+	}
+	secret, err := j.mfa.secret(ctx, wardenMFASecret, ch.UserID)
+	if err != nil {
+		logger.Logger.Errorw("get mfa secret", "userID", ch.UserID, "err", err.Error())
+		return nil, err
+	}
+	ok := false
+	if secret != "" {
+		if ok, err = j.mfa.verifyCode(ctx, ch.UserID, secret, r.Code, recoveryLogin, r.ClientIP); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		if attempts == mfaMaxAttempts {
+			j.mfa.removeChallenge(ctx, r.Challenge)
+		}
+		j.lock.fail(ctx, ch.UserName, r.ClientIP)
+		logger.Logger.Infow("mfa refused", "userID", ch.UserID, "clientIP", r.ClientIP)
+		return nil, error2.New(code.ErrInvalidMFACode)
+	}
+	if ok, err = j.mfa.takeChallenge(ctx, r.Challenge); err != nil {
+		logger.Logger.Errorw("take mfa challenge", "err", err.Error())
+		return nil, err
+	} else if !ok {
+		return nil, error2.New(code.ErrInvalidMFAChallenge)
+	}
+	j.lock.succeed(ctx, ch.UserName)
+
+	return j.issueToken(ctx, &jwts.TokenGenerateRequest{
+		UserID:    ch.UserID,
+		LoginType: ch.LoginType,
+		ClientIP:  r.ClientIP,
+		UserAgent: r.UserAgent,
+	})
+}
+
+// mfaUser the user of the token, mfa must be on
+func (j *jwtServer) mfaUser(c context.Context, token string) (jwts.TokenInfo, error) {
+	if j.mfa == nil {
+		return nil, error2.New(code.ErrMFADisabled)
+	}
+	ti, err := j.s.Manager.LoadAccessToken(c, token)
+	if err != nil || ti.GetUserID() == "" {
+		return nil, error2.New(code.ErrInvalidAccessToken)
+	}
+	return ti, nil
+}
+
+// MFAStatus whether the user of the token has enabled mfa
+func (j *jwtServer) MFAStatus(c context.Context, req *MFARequest) (*MFAStatusResponse, error) {
+	ti, err := j.mfaUser(c, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := j.mfa.enabled(c, ti.GetUserID())
+	if err != nil || !enabled {
+		return &MFAStatusResponse{}, err
+	}
+	left, uses, err := j.mfa.recoveryState(c, ti.GetUserID())
+	if err != nil {
+		return nil, err
+	}
+	return &MFAStatusResponse{
+		Enabled:       enabled,
+		RecoveryCodes: left,
+		RecoveryUses:  uses,
+	}, nil
+}
+
+// EnrollMFA a new secret for the user of the token, it is enabled by ActivateMFA with its first code
+func (j *jwtServer) EnrollMFA(c context.Context, req *MFARequest) (*EnrollMFAResponse, error) {
+	ti, err := j.mfaUser(c, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	userID := ti.GetUserID()
+	if enabled, err := j.mfa.enabled(c, userID); err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, error2.New(code.ErrMFAEnabled)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := j.mfa.seal(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err = j.redisc.Set(c, wardenMFAPending+userID, sealed, mfaEnrollExp).Err(); err != nil {
+		logger.Logger.Errorw("enroll mfa", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	account := ti.GetUserName()
+	if account == "" {
+		account = userID
+	}
+	return &EnrollMFAResponse{
+		Secret: secret,
+		URI:    totp.URI(j.mfa.issuer, account, secret),
+	}, nil
+}
+
+// ActivateMFA enable the enrolled secret when the code of it is right,
+// the recovery codes are generated with it
+func (j *jwtServer) ActivateMFA(c context.Context, req *MFARequest) (*RecoveryCodesResponse, error) {
+	ti, err := j.mfaUser(c, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	userID := ti.GetUserID()
+	secret, err := j.mfa.secret(c, wardenMFAPending, userID)
+	if err != nil {
+		return nil, err
+	} else if secret == "" {
+		return nil, error2.New(code.ErrMFANotEnrolled)
+	}
+	if ok, err := j.mfa.verify(c, userID, secret, req.Code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, error2.New(code.ErrInvalidMFACode)
+	}
+
+	sealed, err := j.mfa.seal(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err = j.redisc.Set(c, wardenMFASecret+userID, sealed, 0).Err(); err != nil {
+		logger.Logger.Errorw("activate mfa", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	j.redisc.Del(c, wardenMFAPending+userID)
+	codes, err := j.mfa.newRecoveryCodes(c, userID)
+	if err != nil {
+		logger.Logger.Errorw("generate recovery codes", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	logger.Logger.Infow("mfa enabled", "userID", userID)
+	return &RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// DisableMFA disable the mfa of the user of the token, the code of it or a recovery code confirms
+func (j *jwtServer) DisableMFA(c context.Context, req *MFARequest) (*MFAResponse, error) {
+	ti, err := j.mfaUser(c, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	userID := ti.GetUserID()
+	secret, err := j.mfa.secret(c, wardenMFASecret, userID)
+	if err != nil {
+		return nil, err
+	} else if secret == "" {
+		return nil, error2.New(code.ErrMFANotEnrolled)
+	}
+	if ok, err := j.mfa.verifyCode(c, userID, secret, req.Code, recoveryDisable, req.ClientIP); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, error2.New(code.ErrInvalidMFACode)
+	}
+	if err = j.redisc.Del(c, wardenMFASecret+userID).Err(); err != nil {
+		logger.Logger.Errorw("disable mfa", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	if err = j.mfa.removeRecovery(c, userID); err != nil {
+		logger.Logger.Errorw("disable mfa", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	logger.Logger.Infow("mfa disabled", "userID", userID)
+	return &MFAResponse{}, nil
+}
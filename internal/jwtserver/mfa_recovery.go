@@ -0,0 +1,200 @@
+package jwtserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/totp"
+)
+
+const (
+	// wardenMFARecovery user id -> hash of the sha256 of the unused recovery codes
+	wardenMFARecovery = "warden:mfa:recovery:"
+	// wardenMFARecoveryUses user id -> the latest uses of the recovery codes
+	wardenMFARecoveryUses = "warden:mfa:recovery:uses:"
+
+	recoveryCodeCount = 10
+	// recoveryCodeSize the random bytes of a code, it is 16 base32 characters
+	recoveryCodeSize = 10
+	maxRecoveryUses  = 20
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryUse a use of a recovery code
+type RecoveryUse struct {
+	UsedAt   time.Time `json:"usedAt"`
+	Action   string    `json:"action"`
+	ClientIP string    `json:"clientIP,omitempty"`
+}
+
+// the actions the recovery codes are used for
+const (
+	recoveryLogin      = "login"
+	recoveryDisable    = "disable"
+	recoveryRegenerate = "regenerate"
+)
+
+// normalizeRecoveryCode the codes are shown grouped by "-" and typed in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes replace the recovery codes of the user, the plain codes are shown once
+func (m *mfa) newRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	fields := make([]interface{}, 0, recoveryCodeCount*2)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(recoveryEncoding.EncodeToString(buf))
+		code := s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+		codes = append(codes, code)
+		fields = append(fields, hashRecoveryCode(code), 1)
+	}
+	if err := m.redisc.Del(ctx, wardenMFARecovery+userID).Err(); err != nil {
+		return nil, err
+	}
+	if err := m.redisc.HSet(ctx, wardenMFARecovery+userID, fields...).Err(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode a recovery code is removed by its use, so it is used once only
+func (m *mfa) useRecoveryCode(ctx context.Context, userID, code, action, clientIP string) (bool, error) {
+	n, err := m.redisc.HDel(ctx, wardenMFARecovery+userID, hashRecoveryCode(code)).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	buf, _ := json.Marshal(&RecoveryUse{
+		UsedAt:   time.Now(),
+		Action:   action,
+		ClientIP: clientIP,
+	})
+	m.redisc.LPush(ctx, wardenMFARecoveryUses+userID, buf)
+	m.redisc.LTrim(ctx, wardenMFARecoveryUses+userID, 0, maxRecoveryUses-1)
+	logger.Logger.Warnw("mfa recovery code used", "userID", userID, "action", action, "clientIP", clientIP)
+	return true, nil
+}
+
+// recoveryState the unused recovery codes and the latest uses of them
+func (m *mfa) recoveryState(ctx context.Context, userID string) (int64, []*RecoveryUse, error) {
+	left, err := m.redisc.HLen(ctx, wardenMFARecovery+userID).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	list, err := m.redisc.LRange(ctx, wardenMFARecoveryUses+userID, 0, -1).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	uses := make([]*RecoveryUse, 0, len(list))
+	for _, v := range list {
+		use := &RecoveryUse{}
+		if json.Unmarshal([]byte(v), use) == nil {
+			uses = append(uses, use)
+		}
+	}
+	return left, uses, nil
+}
+
+// verifyCode a TOTP code of the secret, or a recovery code in place of it
+func (m *mfa) verifyCode(ctx context.Context, userID, secret, code, action, clientIP string) (bool, error) {
+	if len(strings.TrimSpace(code)) == totp.Digits {
+		return m.verify(ctx, userID, secret, code)
+	}
+	return m.useRecoveryCode(ctx, userID, code, action, clientIP)
+}
+
+// reset remove the enabled and the enrolling secret of the user, the keys are apart in a cluster
+func (m *mfa) reset(ctx context.Context, userID string) error {
+	if err := m.redisc.Del(ctx, wardenMFASecret+userID).Err(); err != nil {
+		return err
+	}
+	return m.redisc.Del(ctx, wardenMFAPending+userID).Err()
+}
+
+// removeRecovery remove the recovery codes and their uses, the keys are apart in a cluster
+func (m *mfa) removeRecovery(ctx context.Context, userID string) error {
+	if err := m.redisc.Del(ctx, wardenMFARecovery+userID).Err(); err != nil {
+		return err
+	}
+	return m.redisc.Del(ctx, wardenMFARecoveryUses+userID).Err()
+}
+
+// RecoveryCodesResponse the new recovery codes, they are shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of the user of the token,
+// a code of the authenticator or a recovery code confirms
+func (j *jwtServer) RegenerateRecoveryCodes(c context.Context, req *MFARequest) (*RecoveryCodesResponse, error) {
+	ti, err := j.mfaUser(c, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	userID := ti.GetUserID()
+	secret, err := j.mfa.secret(c, wardenMFASecret, userID)
+	if err != nil {
+		return nil, err
+	} else if secret == "" {
+		return nil, error2.New(code.ErrMFANotEnrolled)
+	}
+	if ok, err := j.mfa.verifyCode(c, userID, secret, req.Code, recoveryRegenerate, req.ClientIP); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, error2.New(code.ErrInvalidMFACode)
+	}
+	codes, err := j.mfa.newRecoveryCodes(c, userID)
+	if err != nil {
+		logger.Logger.Errorw("regenerate recovery codes", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	logger.Logger.Infow("recovery codes regenerated", "userID", userID)
+	return &RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// ResetMFARequest reset the mfa of the user
+type ResetMFARequest struct {
+	UserID string `json:"userID" binding:"required"`
+}
+
+// ResetMFA the admin removes the mfa of the user who lost the authenticator,
+// the tokens of the user are destroyed so every session logs in again
+func (j *jwtServer) ResetMFA(c context.Context, req *ResetMFARequest) (*MFAResponse, error) {
+	if j.mfa == nil {
+		return nil, error2.New(code.ErrMFADisabled)
+	}
+	if err := j.mfa.reset(c, req.UserID); err != nil {
+		logger.Logger.Errorw("reset mfa", "userID", req.UserID, "err", err.Error())
+		return nil, err
+	}
+	if err := j.mfa.removeRecovery(c, req.UserID); err != nil {
+		logger.Logger.Errorw("reset mfa", "userID", req.UserID, "err", err.Error())
+		return nil, err
+	}
+	DestroyToken(c, j.s, j.redisc, req.UserID)
+	logger.Logger.Warnw("mfa reset", "userID", req.UserID)
+	return &MFAResponse{}, nil
+}
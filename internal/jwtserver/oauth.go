@@ -0,0 +1,208 @@
+package jwtserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/configs"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/models"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/store"
+)
+
+// newClientStore the oauth2 clients of the config
+func newClientStore(conf configs.OAuth) jwts.ClientStore {
+	cs := store.NewClientStore()
+	for _, v := range conf.Clients {
+		grantTypes := make([]jwts.GrantType, 0, len(v.GrantTypes))
+		for _, gt := range v.GrantTypes {
+			grantTypes = append(grantTypes, jwts.GrantType(gt))
+		}
+		_ = cs.Set(v.ID, &models.Client{
+			ID:         v.ID,
+			Secret:     v.Secret,
+			Domain:     v.Domain,
+			UserID:     v.UserID,
+			GrantTypes: grantTypes,
+			Scopes:     v.Scopes,
+
+			RedirectURIs: v.RedirectURIs,
+		})
+	}
+	return cs
+}
+
+// passwordAuthorization the password grant checks the account by the authenticator of the login type
+func (j *jwtServer) passwordAuthorization(ctx context.Context, loginType, username, password string) (string, error) {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	if err := j.lock.check(ctx, username, ip); err != nil {
+		return "", err
+	}
+	userAccount, err := j.auth.authenticate(ctx, &Credentials{
+		LoginType: loginType,
+		UserName:  username,
+		Password:  password,
+		ClientIP:  ip,
+	})
+	if err != nil {
+		return "", err
+	}
+	if userAccount.UserID == "" {
+		logger.Logger.Infow("password grant refused", "code", userAccount.Code, "msg", userAccount.Msg)
+		j.lock.fail(ctx, username, ip)
+		return "", errors.ErrInvalidGrant
+	}
+	// the password grant can't ask for the code, so it must not bypass mfa
+	if enabled, err := j.mfa.enabled(ctx, userAccount.UserID); err != nil {
+		return "", err
+	} else if enabled {
+		return "", errMFARequired
+	}
+	j.lock.succeed(ctx, username)
+	// the tenant switched by the last session is dropped before the claims are filled
+	j.redisc.Del(ctx, wardenUserTenantCache+userAccount.UserID)
+	return userAccount.UserID, nil
+}
+
+// claims fill the profile claims, the token is issued without them when the org service fails
+func (j *jwtServer) claims(ctx context.Context, tgr *jwts.TokenGenerateRequest) error {
+	if err := j.fillProfile(ctx, nil, tgr); err != nil {
+		logger.Logger.Warnw("get user info for the token claims", "userID", tgr.UserID, "err", err.Error())
+	}
+	return nil
+}
+
+// introspection the tenant and departments which GetUserInfo resolves,
+// the claims of the token when the org service fails
+func (j *jwtServer) introspection(ctx context.Context, ti jwts.TokenInfo) map[string]interface{} {
+	if ti.GetUserID() == "" {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	info, depID, err := GetUserInfo(ctx, j.org, j.redisc, nil, ti.GetUserID(), j.conf)
+	if err != nil {
+		logger.Logger.Warnw("get user info for the introspection", "userID", ti.GetUserID(), "err", err.Error())
+		if v := ti.GetUserName(); v != "" {
+			fields["name"] = v
+		}
+		if v := ti.GetDepartmentIDs(); len(v) != 0 {
+			fields["dep_ids"] = v
+		}
+		return fields
+	}
+	fields["name"] = info.Name
+	fields["department_id"] = depID
+	if depIDs := GetUserDEPIDs(info.Dep); len(depIDs) != 0 {
+		fields["dep_ids"] = depIDs
+	}
+	if info.TenantID != "" {
+		fields["tenant_id"] = info.TenantID
+	}
+	return fields
+}
+
+// userAuthorization the user of the authorization request is the one of the session,
+// the user is redirected to the login page without a session
+func (j *jwtServer) userAuthorization(w http.ResponseWriter, r *http.Request) (string, error) {
+	if token := sessionToken(r, j.conf.OAuth.SessionCookie); token != "" {
+		ti, err := j.s.Manager.LoadAccessToken(r.Context(), token)
+		if err == nil && ti.GetUserID() != "" {
+			return ti.GetUserID(), nil
+		}
+	}
+	if j.conf.OAuth.LoginURL == "" {
+		return "", errors.ErrAccessDenied
+	}
+
+	loginURL, err := url.Parse(j.conf.OAuth.LoginURL)
+	if err != nil {
+		return "", err
+	}
+	q := loginURL.Query()
+	q.Set("redirect", authorizeEndpoint+"?"+r.Form.Encode())
+	loginURL.RawQuery = q.Encode()
+	w.Header().Set("Location", loginURL.String())
+	w.WriteHeader(http.StatusFound)
+	return "", nil
+}
+
+// sessionToken the access token of a bearer, the Access-Token header or the session cookie
+func sessionToken(r *http.Request, cookie string) string {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		return token
+	}
+	if token := r.Header.Get(accessTokenHeader); token != "" {
+		return token
+	}
+	if cookie == "" {
+		cookie = accessTokenHeader
+	}
+	if c, err := r.Cookie(cookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func bearerToken(auth string) (string, bool) {
+	const prefix = "Bearer "
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return auth[len(prefix):], true
+	}
+	return "", false
+}
+
+func oauthInternalError(err error) *errors.Response {
+	if err == errors.ErrSessionLimitExceeded {
+		re := errors.NewResponse(errors.ErrAccessDenied, errors.StatusCodes[errors.ErrAccessDenied])
+		re.Description = "The user has reached the limit of concurrent sessions"
+		return re
+	}
+	if err == errMFARequired {
+		re := errors.NewResponse(errors.ErrAccessDenied, errors.StatusCodes[errors.ErrAccessDenied])
+		re.Description = "The user has multi-factor authentication, log in with the login endpoint"
+		return re
+	}
+	if err == errUnknownLoginType {
+		re := errors.NewResponse(errors.ErrInvalidRequest, errors.StatusCodes[errors.ErrInvalidRequest])
+		re.Description = "The login type is not supported"
+		return re
+	}
+	if err == errAccountLocked {
+		re := errors.NewResponse(errors.ErrInvalidGrant, errors.StatusCodes[errors.ErrInvalidGrant])
+		re.Description = "Too many failed logins, the account is temporarily locked"
+		return re
+	}
+	logger.Logger.Errorw("oauth token", "err", err.Error())
+	return nil
+}
+
+// Authorize RFC 6749 authorization endpoint, the code flow only
+func (j *jwtServer) Authorize(w http.ResponseWriter, r *http.Request) error {
+	return j.s.HandleAuthorizeRequest(w, r)
+}
+
+// OAuthToken RFC 6749 token endpoint
+func (j *jwtServer) OAuthToken(w http.ResponseWriter, r *http.Request) error {
+	if j.s.ClientIPHandler != nil {
+		r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, j.s.ClientIPHandler(r)))
+	}
+	return j.s.HandleOAuthTokenRequest(w, r)
+}
+
+// clientIPKey the client ip of the token request, the password grant counts the failures by it
+type clientIPKey struct{}
+
+// Introspect RFC 7662 introspection endpoint
+func (j *jwtServer) Introspect(w http.ResponseWriter, r *http.Request) error {
+	return j.s.HandleIntrospectionRequest(w, r)
+}
+
+// Revoke RFC 7009 revocation endpoint
+func (j *jwtServer) Revoke(w http.ResponseWriter, r *http.Request) error {
+	return j.s.HandleRevocationRequest(w, r)
+}
@@ -0,0 +1,211 @@
+package jwtserver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// OpenID Connect scopes
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+	scopePhone   = "phone"
+)
+
+// paths of the endpoints in the discovery document
+const (
+	apiPrefix            = "/api/v1/warden"
+	authorizeEndpoint    = apiPrefix + "/oauth/authorize"
+	tokenEndpoint        = apiPrefix + "/oauth/token"
+	userInfoEndpoint     = apiPrefix + "/oauth/userinfo"
+	revocationEndpoint   = apiPrefix + "/oauth/revoke"
+	introspectEndpoint   = apiPrefix + "/oauth/introspect"
+	jwksEndpoint         = "/.well-known/jwks.json"
+	idTokenExtensionName = "id_token"
+
+	accessTokenHeader = "Access-Token"
+)
+
+// issuer the issuer of the id tokens, the serverHost when the claims have no issuer
+func (j *jwtServer) issuer() string {
+	if v := j.conf.JWTConfig.Claims.Issuer; v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return strings.TrimRight(j.conf.JWTConfig.ServerHost, "/")
+}
+
+// userClaims the standard claims of the user granted by the scope,
+// the tenant and departments come with the profile scope
+func (j *jwtServer) userClaims(ctx context.Context, userID, scope string) (map[string]interface{}, error) {
+	info, depID, err := GetUserInfo(ctx, j.org, j.redisc, nil, userID, j.conf)
+	if err != nil {
+		return nil, err
+	}
+	scopes := strings.Fields(scope)
+	claims := map[string]interface{}{
+		"sub": userID,
+	}
+	for _, v := range scopes {
+		switch v {
+		case scopeProfile:
+			setClaim(claims, "name", info.Name)
+			setClaim(claims, "picture", info.Avatar)
+			setClaim(claims, "tenant_id", info.TenantID)
+			setClaim(claims, "department_id", depID)
+			if depIDs := GetUserDEPIDs(info.Dep); len(depIDs) != 0 {
+				claims["dep_ids"] = depIDs
+			}
+		case scopeEmail:
+			setClaim(claims, "email", info.Email)
+		case scopePhone:
+			setClaim(claims, "phone_number", info.Phone)
+		}
+	}
+	return claims, nil
+}
+
+func setClaim(claims map[string]interface{}, name, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
+
+// oidc the id tokens are issued only when the current key is published by the jwks,
+// a token signed by the jwtKey can't be verified without the secret of warden
+func (j *jwtServer) oidc() bool {
+	return j.ring.CurrentPublished()
+}
+
+// tokenFields the id token is issued with the access token when the openid scope is granted
+func (j *jwtServer) tokenFields(ti jwts.TokenInfo) map[string]interface{} {
+	if !j.oidc() || ti.GetUserID() == "" || !jwts.ContainsScope(ti.GetScope(), scopeOpenID) {
+		return nil
+	}
+	idToken, err := j.idToken(context.Background(), ti)
+	if err != nil {
+		logger.Logger.Errorw("issue id token", "userID", ti.GetUserID(), "err", err.Error())
+		return nil
+	}
+	return map[string]interface{}{
+		idTokenExtensionName: idToken,
+	}
+}
+
+// idToken the id token of the access token, it expires with the access token
+func (j *jwtServer) idToken(ctx context.Context, ti jwts.TokenInfo) (string, error) {
+	claims, err := j.userClaims(ctx, ti.GetUserID(), ti.GetScope())
+	if err != nil {
+		return "", err
+	}
+	claims["iss"] = j.issuer()
+	claims["aud"] = ti.GetClientID()
+	claims["iat"] = ti.GetAccessCreateAt().Unix()
+	claims["exp"] = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
+	if ti.GetAccessExpiresIn() == 0 {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	if nonce := ti.GetNonce(); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return j.ring.Sign(ctx, jwt.MapClaims(claims))
+}
+
+// UserInfo the claims of the user granted to the access token,
+// the token must have the openid scope
+func (j *jwtServer) UserInfo(c context.Context, token string) (map[string]interface{}, error) {
+	ti, err := j.s.Manager.LoadAccessToken(c, token)
+	if err != nil || ti.GetUserID() == "" {
+		return nil, errors.ErrInvalidToken
+	}
+	if !jwts.ContainsScope(ti.GetScope(), scopeOpenID) {
+		return nil, errors.ErrInsufficientScope
+	}
+	return j.userClaims(c, ti.GetUserID(), ti.GetScope())
+}
+
+// DiscoveryResponse OpenID Connect discovery document
+type DiscoveryResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Discovery the OpenID Connect discovery document, openid isn't a scope of it when oidc is off
+func (j *jwtServer) Discovery(c context.Context) (*DiscoveryResponse, error) {
+	issuer := j.issuer()
+	scopes := []string{scopeProfile, scopeEmail, scopePhone}
+	if j.oidc() {
+		scopes = append([]string{scopeOpenID}, scopes...)
+	}
+	algs, err := j.signingAlgs(c)
+	if err != nil {
+		return nil, err
+	}
+	grantTypes := make([]string, 0, len(j.s.Config.AllowedGrantTypes))
+	for _, v := range j.s.Config.AllowedGrantTypes {
+		grantTypes = append(grantTypes, v.String())
+	}
+	responseTypes := make([]string, 0, len(j.s.Config.AllowedResponseTypes))
+	for _, v := range j.s.Config.AllowedResponseTypes {
+		responseTypes = append(responseTypes, v.String())
+	}
+	return &DiscoveryResponse{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + authorizeEndpoint,
+		TokenEndpoint:                     issuer + tokenEndpoint,
+		UserInfoEndpoint:                  issuer + userInfoEndpoint,
+		JWKSURI:                           issuer + jwksEndpoint,
+		RevocationEndpoint:                issuer + revocationEndpoint,
+		IntrospectionEndpoint:             issuer + introspectEndpoint,
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            responseTypes,
+		CodeChallengeMethodsSupported:     []string{string(jwts.CodeChallengeS256)},
+		GrantTypesSupported:               grantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "nonce", "name", "picture", "email", "phone_number",
+			"tenant_id", "department_id", "dep_ids",
+		},
+	}, nil
+}
+
+// signingAlgs the algorithms of the keys in the jwks, the relying parties can verify them only
+func (j *jwtServer) signingAlgs(c context.Context) ([]string, error) {
+	keys, err := j.ring.PublicKeys(c)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{})
+	for _, v := range keys {
+		set[v.Alg] = struct{}{}
+	}
+	algs := make([]string, 0, len(set))
+	for alg := range set {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs, nil
+}
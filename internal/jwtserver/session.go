@@ -0,0 +1,139 @@
+package jwtserver
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	error2 "github.com/quanxiang-cloud/cabin/error"
+	"github.com/quanxiang-cloud/cabin/logger"
+
+	"github.com/quanxiang-cloud/warden/pkg/code"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// Session 一次登录的会话，刷新 token 不会改变会话 id
+type Session struct {
+	ID            string     `json:"id"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	LastRefreshAt *time.Time `json:"lastRefreshAt,omitempty"`
+	LoginType     string     `json:"loginType,omitempty"`
+	ClientID      string     `json:"clientID,omitempty"`
+	ClientIP      string     `json:"clientIP,omitempty"`
+	UserAgent     string     `json:"userAgent,omitempty"`
+	DeviceName    string     `json:"deviceName,omitempty"`
+	// Current the session of the access token of the request
+	Current bool `json:"current"`
+}
+
+// ListSessionsRequest the sessions of the user, or of the user of the token
+type ListSessionsRequest struct {
+	UserID string `json:"userID" form:"userID"`
+	Token  string `json:"-"`
+}
+
+// ListSessionsResponse list sessions response
+type ListSessionsResponse struct {
+	Sessions []*Session `json:"sessions"`
+}
+
+// RevokeSessionRequest revoke one session of the user, or of the user of the token
+type RevokeSessionRequest struct {
+	UserID    string `json:"userID"`
+	SessionID string `json:"sessionID" binding:"required"`
+	Token     string `json:"-"`
+}
+
+// RevokeSessionResponse revoke session response
+type RevokeSessionResponse struct {
+}
+
+// sessionUser the user of the token when it is given, the family of the token is the current session
+func (j *jwtServer) sessionUser(c context.Context, userID, token string) (string, string, error) {
+	if token == "" {
+		if userID == "" {
+			return "", "", error2.New(code.InvalidParams)
+		}
+		return userID, "", nil
+	}
+	ti, err := j.s.Manager.LoadAccessToken(c, token)
+	if err != nil || ti.GetUserID() == "" {
+		return "", "", error2.New(code.ErrInvalidAccessToken)
+	}
+	return ti.GetUserID(), ti.GetFamilyID(), nil
+}
+
+// ListSessions the live sessions of the user, the latest login first
+func (j *jwtServer) ListSessions(c context.Context, req *ListSessionsRequest) (*ListSessionsResponse, error) {
+	userID, current, err := j.sessionUser(c, req.UserID, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := j.s.Manager.GetSessions(c, userID)
+	if err != nil {
+		logger.Logger.Errorw("get sessions", "userID", userID, "err", err.Error())
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(infos))
+	for _, ti := range infos {
+		session := newSession(ti)
+		session.Current = session.ID == current
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, k int) bool {
+		return sessions[i].CreatedAt.After(sessions[k].CreatedAt)
+	})
+	return &ListSessionsResponse{
+		Sessions: sessions,
+	}, nil
+}
+
+func newSession(ti jwts.TokenInfo) *Session {
+	session := &Session{
+		ID:         ti.GetFamilyID(),
+		CreatedAt:  ti.GetCreateAt(),
+		LoginType:  ti.GetLoginType(),
+		ClientID:   ti.GetClientID(),
+		ClientIP:   ti.GetClientIP(),
+		UserAgent:  ti.GetUserAgent(),
+		DeviceName: ti.GetDeviceName(),
+	}
+	// tokens issued before the sessions were tracked have no login time
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = ti.GetRefreshCreateAt()
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = ti.GetAccessCreateAt()
+	}
+	if v := ti.GetLastRefreshAt(); !v.IsZero() {
+		session.LastRefreshAt = &v
+	}
+
+	createAt, exp := ti.GetAccessCreateAt(), ti.GetAccessExpiresIn()
+	if ti.GetRefresh() != "" {
+		createAt, exp = ti.GetRefreshCreateAt(), ti.GetRefreshExpiresIn()
+	}
+	if exp != 0 {
+		expiresAt := createAt.Add(exp)
+		session.ExpiresAt = &expiresAt
+	}
+	return session
+}
+
+// RevokeSession revoke one session, the other sessions of the user are kept
+func (j *jwtServer) RevokeSession(c context.Context, req *RevokeSessionRequest) (*RevokeSessionResponse, error) {
+	userID, _, err := j.sessionUser(c, req.UserID, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if err = j.s.Manager.RevokeSession(c, userID, req.SessionID); err != nil {
+		if err == errors.ErrSessionNotFound {
+			return nil, error2.New(code.ErrSessionNotFound)
+		}
+		logger.Logger.Errorw("revoke session", "userID", userID, "sessionID", req.SessionID, "err", err.Error())
+		return nil, err
+	}
+	return &RevokeSessionResponse{}, nil
+}
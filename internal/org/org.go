@@ -27,10 +27,10 @@ type Org interface {
 }
 
 // NewOrg new
-func NewOrg(conf configs.Config, redisClient redis.UniversalClient) Org {
+func NewOrg(conf configs.Config, s *server.Server, redisClient redis.UniversalClient) Org {
 
 	return &org{
-		s:           jwtserver.NewServer(),
+		s:           s,
 		client:      client.New(conf.InternalNet),
 		conf:        conf,
 		redisClient: redisClient,
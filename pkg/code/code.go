@@ -23,15 +23,48 @@ const (
 	ErrExpiredAccessToken = 20014000005
 	// ErrExpiredRefreshToken ErrExpiredRefreshToken
 	ErrExpiredRefreshToken = 20014000006
+	// ErrInvalidSigningKey 无效的签名密钥
+	ErrInvalidSigningKey = 20014000007
+	// ErrSessionNotFound 会话不存在
+	ErrSessionNotFound = 20014000008
+	// ErrSessionLimitExceeded 会话数已达上限
+	ErrSessionLimitExceeded = 20014000009
+	// ErrAccountLocked 登录失败次数过多，账号已锁定
+	ErrAccountLocked = 20014000010
+	// ErrTooManyRequests 请求过于频繁
+	ErrTooManyRequests = 20014000011
+	// ErrInvalidMFACode 无效的MFA验证码
+	ErrInvalidMFACode = 20014000012
+	// ErrInvalidMFAChallenge 无效的MFA登录挑战
+	ErrInvalidMFAChallenge = 20014000013
+	// ErrMFANotEnrolled 未绑定MFA
+	ErrMFANotEnrolled = 20014000014
+	// ErrMFAEnabled 已开启MFA
+	ErrMFAEnabled = 20014000015
+	// ErrMFADisabled MFA功能未开启
+	ErrMFADisabled = 20014000016
+	// ErrUnknownLoginType 不支持的登录方式
+	ErrUnknownLoginType = 20014000017
 )
 
 // codeTable 码表
 var codeTable = map[int64]string{
-	InvalidURI:             "无效的URI.",
-	InvalidParams:          "无效的参数.",
-	InvalidTimestamp:       "无效的时间格式.",
-	ErrInvalidAccessToken:  "无效的token.",
-	ErrInvalidRefreshToken: "无效的刷新token.",
-	ErrExpiredAccessToken:  "token已经失效.",
-	ErrExpiredRefreshToken: "刷新token已经失效.",
+	InvalidURI:              "无效的URI.",
+	InvalidParams:           "无效的参数.",
+	InvalidTimestamp:        "无效的时间格式.",
+	ErrInvalidAccessToken:   "无效的token.",
+	ErrInvalidRefreshToken:  "无效的刷新token.",
+	ErrExpiredAccessToken:   "token已经失效.",
+	ErrExpiredRefreshToken:  "刷新token已经失效.",
+	ErrInvalidSigningKey:    "无效的签名密钥.",
+	ErrSessionNotFound:      "会话不存在.",
+	ErrSessionLimitExceeded: "会话数已达上限.",
+	ErrAccountLocked:        "登录失败次数过多，账号已锁定，请稍后再试.",
+	ErrTooManyRequests:      "请求过于频繁，请稍后再试.",
+	ErrInvalidMFACode:       "验证码错误.",
+	ErrInvalidMFAChallenge:  "登录验证已失效，请重新登录.",
+	ErrMFANotEnrolled:       "未绑定多因素认证.",
+	ErrMFAEnabled:           "已开启多因素认证.",
+	ErrMFADisabled:          "多因素认证功能未开启.",
+	ErrUnknownLoginType:     "不支持的登录方式.",
 }
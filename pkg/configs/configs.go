@@ -25,6 +25,142 @@ type Config struct {
 	Redis       redis.Config  `yaml:"redis"`
 	OrgAPIs     OrgAPI        `yaml:"orgAPI"`
 	JWTConfig   JWTConfig     `yaml:"jwtConfig"`
+	OAuth       OAuth         `yaml:"oauth"`
+	ExtAuthz    ExtAuthz      `yaml:"extAuthz"`
+	ForwardAuth ForwardAuth   `yaml:"forwardAuth"`
+	Lockout     Lockout       `yaml:"lockout"`
+	RateLimit   RateLimit     `yaml:"rateLimit"`
+	MFA         MFA           `yaml:"mfa"`
+	// Authenticators 登录方式，为空时所有 login_type 都由 org 服务校验
+	Authenticators []Authenticator `yaml:"authenticators"`
+
+	// TrustedProxies X-Forwarded-For is honoured only from these addresses or CIDRs
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// ExtAuthz envoy ext_authz gRPC 服务配置
+type ExtAuthz struct {
+	// Port 监听端口，为空不启动，e.g :8002
+	Port string `yaml:"port"`
+}
+
+// Lockout 登录失败计数与锁定，按账号和客户端 ip 分别计数
+type Lockout struct {
+	Enable bool `yaml:"enable"`
+	// UserThreshold 账号在 window 内失败次数达到后锁定，默认 5
+	UserThreshold int64 `yaml:"userThreshold"`
+	// IPThreshold 客户端 ip 在 window 内失败次数达到后锁定，默认 50
+	IPThreshold int64 `yaml:"ipThreshold"`
+	// Window 失败计数的有效期，秒计，默认 900
+	Window time.Duration `yaml:"window"`
+	// LockDuration 锁定时长，秒计，默认 900
+	LockDuration time.Duration `yaml:"lockDuration"`
+	// DelayAfter 账号失败次数超过后每次登录延迟，延迟从 1 秒起逐次翻倍，默认 3
+	DelayAfter int64 `yaml:"delayAfter"`
+	// MaxDelay 最长延迟，秒计，默认 8
+	MaxDelay time.Duration `yaml:"maxDelay"`
+}
+
+// Authenticator 登录方式，登录请求按 login_type 分派到 kind 对应的认证后端
+type Authenticator struct {
+	// LoginType 登录请求的 login_type
+	LoginType string `yaml:"loginType"`
+	// Kind 认证后端，org 为 org 服务的账号密码校验，默认 org
+	Kind string `yaml:"kind"`
+	// Name 登录页展示的名称，默认 loginType
+	Name string `yaml:"name"`
+	// Disable 关闭的登录方式不可登录，也不在登录方式列表中返回
+	Disable bool `yaml:"disable"`
+	// Options 认证后端的配置，由 kind 对应的后端解释
+	Options map[string]string `yaml:"options"`
+}
+
+// MFA TOTP 多因素认证，开启后绑定了的用户登录需要输入验证码
+type MFA struct {
+	Enable bool `yaml:"enable"`
+	// Issuer 验证器 app 中显示的名称，默认 warden
+	Issuer string `yaml:"issuer"`
+	// EncryptionKey 加密存储 TOTP 密钥的 AES key，base64 编码的 16、24 或 32 字节
+	EncryptionKey string `yaml:"encryptionKey"`
+	// ChallengeExp 登录验证的有效期，秒计，默认 300
+	ChallengeExp time.Duration `yaml:"challengeExp"`
+}
+
+// RateLimit 接口限流，各实例通过 redis 共享计数
+type RateLimit struct {
+	Enable bool            `yaml:"enable"`
+	Rules  []RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule 限流规则，请求匹配的规则都会生效
+type RateLimitRule struct {
+	// Path 路由，e.g /api/v1/warden/login，以 * 结尾按前缀匹配
+	Path string `yaml:"path"`
+	// Key 计数维度 ip|user|client，client 仅取校验过密钥的客户端，取不到用户或客户端时按 ip
+	Key string `yaml:"key"`
+	// Limit window 内允许的请求数
+	Limit int64 `yaml:"limit"`
+	// Window 滑动窗口，秒计
+	Window time.Duration `yaml:"window"`
+}
+
+// ForwardAuth traefik forwardAuth、nginx auth_request、caddy forward_auth 鉴权配置
+type ForwardAuth struct {
+	// Cookie 读取 token 的 cookie 名，默认 Access-Token
+	Cookie string `yaml:"cookie"`
+	// QueryParam 读取 token 的 query 参数名，默认 access_token
+	QueryParam string `yaml:"queryParam"`
+	// LoginURL 浏览器请求未登录时跳转的登录页，原地址放在 redirect 参数，为空返回 401
+	LoginURL string `yaml:"loginURL"`
+	// Rules 按路径匹配的规则，取第一条匹配的，都不匹配的需要登录
+	Rules []ForwardAuthRule `yaml:"rules"`
+	// Headers 返回的身份头名称，为空的使用默认值，"-" 不返回
+	Headers ForwardAuthHeaders `yaml:"headers"`
+}
+
+// ForwardAuthRule 路径规则，path 以 * 结尾按前缀匹配
+type ForwardAuthRule struct {
+	Path string `yaml:"path"`
+	// Methods 匹配的方法，为空匹配全部
+	Methods []string `yaml:"methods"`
+	// Public 公开的路径无需登录
+	Public bool `yaml:"public"`
+}
+
+// ForwardAuthHeaders 身份头名称
+type ForwardAuthHeaders struct {
+	UserID       string `yaml:"userID"`
+	UserName     string `yaml:"userName"`
+	DepartmentID string `yaml:"departmentID"`
+	TenantID     string `yaml:"tenantID"`
+}
+
+// OAuth oauth2 配置
+type OAuth struct {
+	Clients []OAuthClient `yaml:"clients"`
+	// LoginURL 授权码流程未登录时跳转的登录页，登录后回到 redirect 参数的地址
+	LoginURL string `yaml:"loginURL"`
+	// SessionCookie 授权码流程读取登录态的 cookie 名，默认 Access-Token
+	SessionCookie string `yaml:"sessionCookie"`
+	// CodeExp 授权码有效期，秒计，默认 600
+	CodeExp time.Duration `yaml:"codeExp"`
+	// ForcePKCE 机密客户端也必须使用 PKCE
+	ForcePKCE bool `yaml:"forcePKCE"`
+}
+
+// OAuthClient oauth2 客户端，secret 为空的是公开客户端
+type OAuthClient struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	Domain string `yaml:"domain"`
+	// UserID subject of the client credentials tokens
+	UserID string `yaml:"userID"`
+	// GrantTypes allowed grant types, all when empty
+	GrantTypes []string `yaml:"grantTypes"`
+	// Scopes allowed scopes, any when empty
+	Scopes []string `yaml:"scopes"`
+	// RedirectURIs redirect uris of the authorization code flow, exactly matched
+	RedirectURIs []string `yaml:"redirectURIs"`
 }
 
 // Service service config
@@ -48,8 +184,109 @@ type OrgAPI struct {
 type JWTConfig struct {
 	AccessTokenExp  time.Duration `yaml:"accessTokenExp"`
 	RefreshTokenExp time.Duration `yaml:"refreshTokenExp"`
-	JwtKey          string        `yaml:"jwtKey"`
-	ServerHost      string        `yaml:"serverHost"`
+	// ResetRefreshTime restart the refresh token lifetime on every refresh
+	ResetRefreshTime bool `yaml:"resetRefreshTime"`
+	// LoginTypes token lifetimes by login type
+	LoginTypes map[string]TokenLifetime `yaml:"loginTypes"`
+	// Clients token lifetimes by client id, they override the login types for the tokens of /oauth/token
+	Clients    map[string]TokenLifetime `yaml:"clients"`
+	JwtKey     string                   `yaml:"jwtKey"`
+	ServerHost string                   `yaml:"serverHost"`
+	TokenStore TokenStore               `yaml:"tokenStore"`
+	// SigningMethod HS256|RS256|PS256|ES256..., default HS256 signed by JwtKey
+	SigningMethod string `yaml:"signingMethod"`
+	// KeyID kid of the signing key
+	KeyID string `yaml:"keyID"`
+	// PrivateKeyFile pem private key for RS/PS/ES methods
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	// PublicKeyFile pem public key, derived from the private key when empty
+	PublicKeyFile string `yaml:"publicKeyFile"`
+	// JWKSMaxAge jwks cache max age in seconds
+	JWKSMaxAge time.Duration `yaml:"jwksMaxAge"`
+	// KeyRing rotating signing keys, the single key above is used when it has no keys
+	KeyRing KeyRing `yaml:"keyRing"`
+	// Claims issuer and audience of the tokens
+	Claims Claims `yaml:"claims"`
+	// SessionLimit concurrent sessions of a user
+	SessionLimit SessionLimit `yaml:"sessionLimit"`
+	// TenantSessionLimits session limits by tenant id, they override the session limit
+	TenantSessionLimits map[string]SessionLimit `yaml:"tenantSessionLimits"`
+	// Stateless verify the access tokens of /check by the signature and a denylist
+	Stateless Stateless `yaml:"stateless"`
+}
+
+// Stateless 无状态校验配置
+type Stateless struct {
+	Enable bool `yaml:"enable"`
+	// SyncInterval seconds between syncing the denylist, the longest delay of a revocation
+	SyncInterval time.Duration `yaml:"syncInterval"`
+}
+
+// SessionLimit 并发会话限制
+type SessionLimit struct {
+	// MaxSessions 0 means no limit
+	MaxSessions int `yaml:"maxSessions"`
+	// Policy reject|evictOldest, default evictOldest
+	Policy string `yaml:"policy"`
+	// PerLoginType every login type has its own limit
+	PerLoginType bool `yaml:"perLoginType"`
+}
+
+// Claims token claims config
+type Claims struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// AcceptLegacy accept the tokens issued before the claims model, user id in jti
+	AcceptLegacy bool `yaml:"acceptLegacy"`
+}
+
+// KeyRing signing key ring
+type KeyRing struct {
+	// Current id of the key which signs the tokens
+	Current string `yaml:"current"`
+	// GracePeriod hours a rotated out key keeps verifying
+	GracePeriod time.Duration `yaml:"gracePeriod"`
+	// SyncInterval seconds between syncing the rotation state of the cluster
+	SyncInterval time.Duration `yaml:"syncInterval"`
+	Keys         []SigningKey  `yaml:"keys"`
+}
+
+// SigningKey one key of the key ring
+type SigningKey struct {
+	ID string `yaml:"id"`
+	// SigningMethod HS256|RS256|PS256|ES256...
+	SigningMethod string `yaml:"signingMethod"`
+	// Key secret of HS methods
+	Key            string `yaml:"key"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	PublicKeyFile  string `yaml:"publicKeyFile"`
+	// RetireAt RFC3339 time after which the key stops verifying
+	RetireAt string `yaml:"retireAt"`
+}
+
+// TokenLifetime token 有效期，小时计，0 沿用全局有效期
+type TokenLifetime struct {
+	AccessTokenExp  time.Duration `yaml:"accessTokenExp"`
+	RefreshTokenExp time.Duration `yaml:"refreshTokenExp"`
+}
+
+// token store types
+const (
+	TokenStoreRedis  = "redis"
+	TokenStoreMemory = "memory"
+	TokenStoreSQL    = "sql"
+)
+
+// TokenStore token 存储配置
+type TokenStore struct {
+	// Type redis|memory|sql, default redis
+	Type string `yaml:"type"`
+	// SweepInterval memory store sweep interval and sql store purge interval in seconds
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+	// Driver database/sql driver name, postgres or sqlite
+	Driver string `yaml:"driver"`
+	// DSN database/sql data source name
+	DSN string `yaml:"dsn"`
 }
 
 // NewConfig 获取配置配置
@@ -62,10 +299,12 @@ func NewConfig(path string) error {
 	if err != nil {
 		return err
 	}
-	err = yaml.Unmarshal(file, &conf)
+	c := new(Config)
+	err = yaml.Unmarshal(file, c)
 	if err != nil {
 		return err
 	}
+	conf = c
 	return nil
 }
 
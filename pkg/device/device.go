@@ -0,0 +1,130 @@
+// Package device the client address and the device of a request
+package device
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxUserAgent user agents are cut to this length before they are stored
+const maxUserAgent = 512
+
+// Proxies trusted proxies, X-Forwarded-For is only honoured when it comes through them
+type Proxies []*net.IPNet
+
+// ParseProxies parse the addresses or the CIDRs of the trusted proxies
+func ParseProxies(proxies []string) (Proxies, error) {
+	nets := make(Proxies, 0, len(proxies))
+	for _, v := range proxies {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: v}
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (p Proxies) trusted(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP the address of the client. X-Forwarded-For is walked from the right
+// while the hops are trusted proxies, the first untrusted hop is the client.
+func (p Proxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(r.RemoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.trusted(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !p.trusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// UserAgent the user agent of the request, cut to a length fit for storing
+func UserAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgent {
+		ua = ua[:maxUserAgent]
+	}
+	return ua
+}
+
+// browsers the first match wins, so the ones built on Chrome or Safari come first
+var browsers = []struct{ token, name string }{
+	{"MicroMessenger/", "WeChat"},
+	{"DingTalk/", "DingTalk"},
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"CriOS/", "Chrome"},
+	{"Version/", "Safari"},
+	{"curl/", "curl"},
+	{"okhttp/", "OkHttp"},
+	{"Go-http-client/", "Go"},
+}
+
+var systems = []struct{ token, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// Name a readable name of the device, e.g. "Chrome on macOS", empty when the user agent is unknown
+func Name(userAgent string) string {
+	browser, system := match(userAgent, browsers), match(userAgent, systems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	}
+	return system
+}
+
+func match(userAgent string, names []struct{ token, name string }) string {
+	for _, v := range names {
+		if strings.Contains(userAgent, v.token) {
+			return v.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package jwts
+
+import "context"
+
+type (
+	// ClientInfo the client information model interface
+	ClientInfo interface {
+		GetID() string
+		GetSecret() string
+		GetDomain() string
+		GetUserID() string
+		// GetGrantTypes the grant types the client may use, all when empty
+		GetGrantTypes() []GrantType
+		// GetScopes the scopes the client may request, any when empty
+		GetScopes() []string
+		// GetRedirectURIs the redirect URIs allowed for the authorization code flow
+		GetRedirectURIs() []string
+	}
+
+	// ClientStore the client information storage interface
+	ClientStore interface {
+		// GetByID according to the ID for the client information
+		GetByID(ctx context.Context, id string) (ClientInfo, error)
+	}
+)
@@ -0,0 +1,72 @@
+package jwts
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// ResponseType the type of authorization request
+type ResponseType string
+
+// define the type of authorization request
+const (
+	Code ResponseType = "code"
+)
+
+func (rt ResponseType) String() string {
+	return string(rt)
+}
+
+// GrantType authorization model
+type GrantType string
+
+// define authorization model
+const (
+	AuthorizationCode   GrantType = "authorization_code"
+	PasswordCredentials GrantType = "password"
+	ClientCredentials   GrantType = "client_credentials"
+	Refreshing          GrantType = "refresh_token"
+)
+
+func (gt GrantType) String() string {
+	return string(gt)
+}
+
+// CodeChallengeMethod PKCE method, only S256 is supported
+type CodeChallengeMethod string
+
+const (
+	// CodeChallengeS256 S256
+	CodeChallengeS256 CodeChallengeMethod = "S256"
+)
+
+// Validate code challenge method
+func (ccm CodeChallengeMethod) Validate() bool {
+	return ccm == CodeChallengeS256
+}
+
+// Verify the code verifier matches the challenge
+func (ccm CodeChallengeMethod) Verify(cc, verifier string) bool {
+	if ccm != CodeChallengeS256 {
+		return false
+	}
+	s256 := sha256.Sum256([]byte(verifier))
+	a := base64.RawURLEncoding.EncodeToString(s256[:])
+	return subtle.ConstantTimeCompare([]byte(a), []byte(cc)) == 1
+}
+
+// ContainsScope every scope of the requested space separated scopes is granted
+func ContainsScope(granted, requested string) bool {
+	set := make(map[string]struct{})
+	for _, v := range strings.Fields(granted) {
+		set[v] = struct{}{}
+	}
+	for _, v := range strings.Fields(requested) {
+		if _, ok := set[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
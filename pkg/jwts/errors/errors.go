@@ -7,10 +7,18 @@ var New = errors.New
 
 // known errors
 var (
-	ErrInvalidRedirectURI    = errors.New("invalid redirect uri")
-	ErrInvalidAccessToken    = errors.New("invalid access token")
-	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
-	ErrExpiredAccessToken    = errors.New("expired access token")
-	ErrExpiredRefreshToken   = errors.New("expired refresh token")
-	ErrUnSupportedSignMethod = errors.New("unsupported sign method")
+	ErrInvalidRedirectURI             = errors.New("invalid redirect uri")
+	ErrInvalidAuthorizeCode           = errors.New("invalid authorize code")
+	ErrMissingCodeVerifier            = errors.New("missing code verifier")
+	ErrMissingCodeChallenge           = errors.New("missing code challenge")
+	ErrInvalidCodeChallenge           = errors.New("invalid code challenge")
+	ErrUnsupportedCodeChallengeMethod = errors.New("unsupported code challenge method")
+	ErrInvalidAccessToken             = errors.New("invalid access token")
+	ErrInvalidRefreshToken            = errors.New("invalid refresh token")
+	ErrExpiredAccessToken             = errors.New("expired access token")
+	ErrExpiredRefreshToken            = errors.New("expired refresh token")
+	ErrReusedRefreshToken             = errors.New("reused refresh token")
+	ErrSessionNotFound                = errors.New("session not found")
+	ErrSessionLimitExceeded           = errors.New("session limit exceeded")
+	ErrUnSupportedSignMethod          = errors.New("unsupported sign method")
 )
@@ -0,0 +1,32 @@
+package jwts
+
+import (
+	"context"
+	"time"
+)
+
+// EventType security event type
+type EventType string
+
+// security events
+const (
+	// EventRefreshTokenReused a rotated refresh token was presented again, the family is revoked
+	EventRefreshTokenReused EventType = "refresh_token_reused"
+	// EventSessionEvicted the oldest session is revoked for a login over the session limit
+	EventSessionEvicted EventType = "session_evicted"
+	// EventSessionLimitRejected a login over the session limit is rejected
+	EventSessionLimitRejected EventType = "session_limit_rejected"
+)
+
+type (
+	// Event security event of the token management
+	Event struct {
+		Type     EventType
+		UserID   string
+		FamilyID string
+		Time     time.Time
+	}
+
+	// EventHandler receive the security events
+	EventHandler func(ctx context.Context, e *Event)
+)
@@ -8,11 +8,10 @@ import (
 type (
 	// GenerateBasic provide the basis of the generated token data
 	GenerateBasic struct {
+		UserID    string
 		Jti       string
 		CreateAt  time.Time
 		TokenInfo TokenInfo
-
-		OtherInfo string
 	}
 	// AccessGenerate generate the access and refresh tokens interface
 	AccessGenerate interface {
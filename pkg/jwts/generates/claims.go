@@ -0,0 +1,181 @@
+package generates
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// ClaimsVersion version of the claims model, the tokens without it are legacy tokens
+const ClaimsVersion = 1
+
+// the other info keys of the legacy tokens
+const (
+	legacyUserName     = "User-Name"
+	legacyDepartmentID = "Department-Id"
+)
+
+// JWTAccessClaims jwt claims, sub is the user id and jti is random
+type JWTAccessClaims struct {
+	jwt.StandardClaims
+
+	Version       int        `json:"ver,omitempty"`
+	SessionID     string     `json:"sid,omitempty"`
+	TenantID      string     `json:"tenant_id,omitempty"`
+	Name          string     `json:"name,omitempty"`
+	DepartmentIDs [][]string `json:"dep_ids,omitempty"`
+	ClientID      string     `json:"client_id,omitempty"`
+	Scope         string     `json:"scope,omitempty"`
+}
+
+// Valid claims verification
+func (a *JWTAccessClaims) Valid() error {
+	if time.Unix(a.ExpiresAt, 0).Before(time.Now()) {
+		return errors.ErrInvalidAccessToken
+	}
+	return a.StandardClaims.Valid()
+}
+
+// UserID the user of the token
+func (a *JWTAccessClaims) UserID() string {
+	return a.Subject
+}
+
+// IsLegacy the token was issued before the claims model
+func (a *JWTAccessClaims) IsLegacy() bool {
+	return a.Version == 0
+}
+
+// DepartmentID the department paths joined as the Department-Id header,
+// ids of a path are joined by "," and the paths by "|"
+func (a *JWTAccessClaims) DepartmentID() string {
+	paths := make([]string, 0, len(a.DepartmentIDs))
+	for _, v := range a.DepartmentIDs {
+		paths = append(paths, strings.Join(v, ","))
+	}
+	return strings.Join(paths, "|")
+}
+
+// ClaimsOptions the issuer and audience of the generated tokens,
+// an empty value is neither issued nor verified
+type ClaimsOptions struct {
+	Issuer   string
+	Audience string
+	// AcceptLegacy accept the tokens issued before the claims model during the migration
+	AcceptLegacy bool
+}
+
+// accessClaims the claims of the access token
+func (o *ClaimsOptions) accessClaims(data *jwts.GenerateBasic) *JWTAccessClaims {
+	ti := data.TokenInfo
+	return &JWTAccessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        data.Jti,
+			Subject:   data.UserID,
+			Issuer:    o.Issuer,
+			Audience:  o.Audience,
+			IssuedAt:  data.CreateAt.Unix(),
+			NotBefore: data.CreateAt.Unix(),
+			ExpiresAt: ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix(),
+		},
+		Version:       ClaimsVersion,
+		SessionID:     ti.GetFamilyID(),
+		TenantID:      ti.GetTenantID(),
+		Name:          ti.GetUserName(),
+		DepartmentIDs: ti.GetDepartmentIDs(),
+		ClientID:      ti.GetClientID(),
+		Scope:         ti.GetScope(),
+	}
+}
+
+// check the issuer and audience of the verified claims, nil if they don't match
+func (o *ClaimsOptions) check(claims map[string]interface{}) map[string]interface{} {
+	if claims == nil {
+		return nil
+	}
+	if _, ok := claims["ver"]; !ok {
+		if o.AcceptLegacy {
+			return claims
+		}
+		return nil
+	}
+	if o.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != o.Issuer {
+			return nil
+		}
+	}
+	if o.Audience != "" && !hasAudience(claims["aud"], o.Audience) {
+		return nil
+	}
+	return claims
+}
+
+// VerifyClaims verify the token by the key as warden does and parse its claims,
+// services which verify the tokens themselves use it so they can't drift from the issuance
+func (o *ClaimsOptions) VerifyClaims(token string, method jwt.SigningMethod, key interface{}) (*JWTAccessClaims, error) {
+	return ParseClaims(o.check(verifyToken(token, method, key)))
+}
+
+// hasAudience aud is a string or an array of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == audience {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range v {
+			if s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseClaims convert the verified claims to the claims model,
+// legacy tokens carry the user id in jti and the other info as json in sub
+func ParseClaims(claims map[string]interface{}) (*JWTAccessClaims, error) {
+	if claims == nil {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	buf, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	res := &JWTAccessClaims{}
+	if err = json.Unmarshal(buf, res); err != nil {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	if !res.IsLegacy() {
+		// the client credentials tokens of a client without user have no subject
+		if res.Subject == "" && res.ClientID == "" {
+			return nil, errors.ErrInvalidAccessToken
+		}
+		return res, nil
+	}
+
+	other := make(map[string]string)
+	if res.Subject != "" {
+		_ = json.Unmarshal([]byte(res.Subject), &other)
+	}
+	res.Subject, res.Id = res.Id, ""
+	if res.Subject == "" {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	res.Name = other[legacyUserName]
+	if depID := other[legacyDepartmentID]; depID != "" {
+		for _, path := range strings.Split(depID, "|") {
+			res.DepartmentIDs = append(res.DepartmentIDs, strings.Split(path, ","))
+		}
+	}
+	return res, nil
+}
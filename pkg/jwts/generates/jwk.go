@@ -0,0 +1,148 @@
+package generates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+const (
+	useSig = "sig"
+	ktyRSA = "RSA"
+	ktyEC  = "EC"
+)
+
+// signingKey parse the key which signs the token
+func signingKey(method jwt.SigningMethod, key []byte) (interface{}, error) {
+	switch {
+	case isEs(method):
+		return jwt.ParseECPrivateKeyFromPEM(key)
+	case isRsOrPS(method):
+		return jwt.ParseRSAPrivateKeyFromPEM(key)
+	case isHs(method):
+		return key, nil
+	}
+	return nil, errors.ErrUnSupportedSignMethod
+}
+
+// verifyKey parse the key which verifies the token,
+// the public key is derived from the signing key when it is not given
+func verifyKey(method jwt.SigningMethod, key, pubKey []byte) (interface{}, error) {
+	switch {
+	case isHs(method):
+		return key, nil
+	case isEs(method):
+		if pubKey != nil {
+			return jwt.ParseECPublicKeyFromPEM(pubKey)
+		}
+		v, err := jwt.ParseECPrivateKeyFromPEM(key)
+		if err != nil {
+			return nil, err
+		}
+		return &v.PublicKey, nil
+	case isRsOrPS(method):
+		if pubKey != nil {
+			return jwt.ParseRSAPublicKeyFromPEM(pubKey)
+		}
+		v, err := jwt.ParseRSAPrivateKeyFromPEM(key)
+		if err != nil {
+			return nil, err
+		}
+		return &v.PublicKey, nil
+	}
+	return nil, errors.ErrUnSupportedSignMethod
+}
+
+// publicJWK convert the verify key to a json web key,
+// ok is false for symmetric keys which must not be published
+func publicJWK(kid string, method jwt.SigningMethod, key interface{}) (jwk jwts.JSONWebKey, ok bool) {
+	jwk = jwts.JSONWebKey{
+		Use: useSig,
+		Kid: kid,
+		Alg: method.Alg(),
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = ktyRSA
+		jwk.N = encodeSegment(k.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(k.E)).Bytes())
+		return jwk, true
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = ktyEC
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = encodeSegment(padBytes(k.X.Bytes(), size))
+		jwk.Y = encodeSegment(padBytes(k.Y.Bytes(), size))
+		return jwk, true
+	}
+	return jwk, false
+}
+
+// ParseJWK the signing method and the verify key of a published json web key
+func ParseJWK(jwk jwts.JSONWebKey) (jwt.SigningMethod, interface{}, error) {
+	method := jwt.GetSigningMethod(jwk.Alg)
+	if method == nil {
+		return nil, nil, errors.ErrUnSupportedSignMethod
+	}
+	switch {
+	case jwk.Kty == ktyRSA && isRsOrPS(method):
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, nil, err
+		}
+		return method, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case jwk.Kty == ktyEC && isEs(method):
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case elliptic.P256().Params().Name:
+			curve = elliptic.P256()
+		case elliptic.P384().Params().Name:
+			curve = elliptic.P384()
+		case elliptic.P521().Params().Name:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, errors.ErrUnSupportedSignMethod
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, nil, err
+		}
+		return method, &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, nil, errors.ErrUnSupportedSignMethod
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padBytes left pad the coordinate to the curve size as RFC 7518 requires
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
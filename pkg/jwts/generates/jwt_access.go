@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/base64"
 	"github.com/quanxiang-cloud/warden/pkg/jwts"
-	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
@@ -20,19 +19,6 @@ const (
 	hs  = "HS"
 )
 
-// JWTAccessClaims jwt claims
-type JWTAccessClaims struct {
-	jwt.StandardClaims
-}
-
-// Valid claims verification
-func (a *JWTAccessClaims) Valid() error {
-	if time.Unix(a.ExpiresAt, 0).Before(time.Now()) {
-		return errors.ErrInvalidAccessToken
-	}
-	return nil
-}
-
 // NewJWTAccessGenerate create to generate the jwt access token instance
 func NewJWTAccessGenerate(kid string, key, pubKey []byte, method jwt.SigningMethod) *JWTAccessGenerate {
 	return &JWTAccessGenerate{
@@ -49,78 +35,89 @@ type JWTAccessGenerate struct {
 	SignedKey    []byte
 	PubKey       []byte
 	SignedMethod jwt.SigningMethod
+
+	ClaimsOptions
 }
 
 // Token based on the UUID generated token
 func (a *JWTAccessGenerate) Token(ctx context.Context, data *jwts.GenerateBasic, isGenRefresh bool) (string, string, error) {
-	claims := &JWTAccessClaims{
-		StandardClaims: jwt.StandardClaims{
-			Id:        data.Jti,
-			ExpiresAt: data.TokenInfo.GetAccessCreateAt().Add(data.TokenInfo.GetAccessExpiresIn()).Unix(),
-			Subject:   data.OtherInfo,
-		},
-	}
-
-	token := jwt.NewWithClaims(a.SignedMethod, claims)
-	if a.SignedKeyID != "" {
-		token.Header[kid] = a.SignedKeyID
-	}
-	var key interface{}
-	if a.isEs() {
-		v, err := jwt.ParseECPrivateKeyFromPEM(a.SignedKey)
-		if err != nil {
-			return "", "", err
-		}
-		key = v
-	} else if a.isRsOrPS() {
-		v, err := jwt.ParseRSAPrivateKeyFromPEM(a.SignedKey)
-		if err != nil {
-			return "", "", err
-		}
-		key = v
-	} else if a.isHs() {
-		key = a.SignedKey
-	} else {
-		return "", "", errors.ErrUnSupportedSignMethod
+	key, err := signingKey(a.SignedMethod, a.SignedKey)
+	if err != nil {
+		return "", "", err
 	}
 
-	access, err := token.SignedString(key)
+	access, err := signToken(a.SignedKeyID, a.SignedMethod, key, a.accessClaims(data))
 	if err != nil {
 		return "", "", err
 	}
 	refresh := ""
 
 	if isGenRefresh {
-		t := uuid.NewSHA1(uuid.Must(uuid.NewRandom()), []byte(access)).String()
-		refresh = base64.URLEncoding.EncodeToString([]byte(t))
-		refresh = strings.ToUpper(strings.TrimRight(refresh, "="))
+		refresh = refreshToken(access)
 	}
 
 	return access, refresh, nil
 }
 
-func (a *JWTAccessGenerate) isEs() bool {
-	return strings.HasPrefix(a.SignedMethod.Alg(), es)
+// signToken sign the claims, the kid is stamped in the header when it is set
+func signToken(keyID string, method jwt.SigningMethod, key interface{}, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(method, claims)
+	if keyID != "" {
+		token.Header[kid] = keyID
+	}
+	return token.SignedString(key)
 }
 
-func (a *JWTAccessGenerate) isRsOrPS() bool {
-	isRs := strings.HasPrefix(a.SignedMethod.Alg(), rs)
-	isPs := strings.HasPrefix(a.SignedMethod.Alg(), ps)
+// refreshToken based on the UUID generated refresh token
+func refreshToken(access string) string {
+	t := uuid.NewSHA1(uuid.Must(uuid.NewRandom()), []byte(access)).String()
+	refresh := base64.URLEncoding.EncodeToString([]byte(t))
+	return strings.ToUpper(strings.TrimRight(refresh, "="))
+}
+
+func isEs(method jwt.SigningMethod) bool {
+	return strings.HasPrefix(method.Alg(), es)
+}
+
+func isRsOrPS(method jwt.SigningMethod) bool {
+	isRs := strings.HasPrefix(method.Alg(), rs)
+	isPs := strings.HasPrefix(method.Alg(), ps)
 	return isRs || isPs
 }
 
-func (a *JWTAccessGenerate) isHs() bool {
-	return strings.HasPrefix(a.SignedMethod.Alg(), hs)
+func isHs(method jwt.SigningMethod) bool {
+	return strings.HasPrefix(method.Alg(), hs)
+}
+
+// PublicKeys the public key which verifies the token, nothing for HS methods
+func (a *JWTAccessGenerate) PublicKeys(ctx context.Context) ([]jwts.JSONWebKey, error) {
+	if isHs(a.SignedMethod) {
+		return nil, nil
+	}
+	key, err := verifyKey(a.SignedMethod, a.SignedKey, a.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	if jwk, ok := publicJWK(a.SignedKeyID, a.SignedMethod, key); ok {
+		return []jwts.JSONWebKey{jwk}, nil
+	}
+	return nil, nil
 }
 
 // Verify Verify token
 func (a *JWTAccessGenerate) Verify(ctx context.Context, ssoToken string) map[string]interface{} {
+	key, err := verifyKey(a.SignedMethod, a.SignedKey, a.PubKey)
+	if err != nil {
+		return nil
+	}
+	return a.check(verifyToken(ssoToken, a.SignedMethod, key))
+}
+
+// verifyToken verify the signature and the expiry of the token
+func verifyToken(ssoToken string, method jwt.SigningMethod, key interface{}) map[string]interface{} {
 	parts := strings.Split(ssoToken, ".")
-	var key []byte = nil
-	if a.PubKey != nil {
-		key = a.PubKey
-	} else {
-		key = a.SignedKey
+	if len(parts) != 3 {
+		return nil
 	}
 	token, _ := jwt.Parse(ssoToken, func(token *jwt.Token) (interface{}, error) {
 		return key, nil
@@ -128,22 +125,16 @@ func (a *JWTAccessGenerate) Verify(ctx context.Context, ssoToken string) map[str
 	if token == nil {
 		return nil
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	if claims.VerifyExpiresAt(time.Now().Unix(), false) == false {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyExpiresAt(time.Now().Unix(), false) {
 		return nil
 	}
-	err := a.SignedMethod.Verify(strings.Join(parts[0:2], "."), parts[2], key)
+	err := method.Verify(strings.Join(parts[0:2], "."), parts[2], key)
 	if err != nil {
 		return nil
 	}
 	if !token.Valid {
 		return nil
 	}
-	if token != nil {
-		if !token.Valid {
-			return nil
-		}
-		return token.Claims.(jwt.MapClaims)
-	}
-	return nil
+	return claims
 }
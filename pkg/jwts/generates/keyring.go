@@ -0,0 +1,327 @@
+package generates
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+var (
+	_ jwts.AccessGenerate = &KeyRing{}
+	_ jwts.KeyPublisher   = &KeyRing{}
+)
+
+// key status
+const (
+	KeyCurrent = "current"
+	KeyActive  = "active"
+	KeyRetired = "retired"
+)
+
+// known errors
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("signing key is retired")
+	ErrNoKey      = errors.New("no signing key")
+)
+
+// SigningKey key material of the key ring,
+// a key without ID verifies the tokens which carry no kid
+type SigningKey struct {
+	ID       string
+	Method   jwt.SigningMethod
+	Key      []byte
+	PubKey   []byte
+	RetireAt time.Time
+}
+
+// KeyStatus the visible state of a key, the key material is never exposed
+type KeyStatus struct {
+	ID       string     `json:"kid"`
+	Alg      string     `json:"alg"`
+	Status   string     `json:"status"`
+	RetireAt *time.Time `json:"retireAt,omitempty"`
+}
+
+type ringKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	retireAt  time.Time
+}
+
+func (k *ringKey) retired(now time.Time) bool {
+	return !k.retireAt.IsZero() && !k.retireAt.After(now)
+}
+
+func newRingKey(key *SigningKey) (*ringKey, error) {
+	if key.Method == nil {
+		return nil, errors.ErrUnSupportedSignMethod
+	}
+	sk, err := signingKey(key.Method, key.Key)
+	if err != nil {
+		return nil, err
+	}
+	vk, err := verifyKey(key.Method, key.Key, key.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ringKey{
+		id:        key.ID,
+		method:    key.Method,
+		signKey:   sk,
+		verifyKey: vk,
+		retireAt:  key.RetireAt,
+	}, nil
+}
+
+// NewKeyRing create a key ring, the current key signs the tokens,
+// the previous keys keep verifying until they are retired,
+// gracePeriod is how long a rotated out key stays valid
+func NewKeyRing(current string, gracePeriod time.Duration, keys ...*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{
+		gracePeriod: gracePeriod,
+		keys:        make(map[string]*ringKey),
+	}
+	if err := ring.Load(current, keys...); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// KeyRing generate the jwt access token with a set of rotating keys
+type KeyRing struct {
+	mu          sync.RWMutex
+	gracePeriod time.Duration
+	current     string
+	keys        map[string]*ringKey
+
+	ClaimsOptions
+}
+
+// Load replace the key material, the current key must be one of the keys.
+// A key dropped from the set is retired after the grace period as long as
+// its material is known, a changed current key is a rotation.
+func (r *KeyRing) Load(current string, keys ...*SigningKey) error {
+	next := make(map[string]*ringKey, len(keys))
+	for _, key := range keys {
+		k, err := newRingKey(key)
+		if err != nil {
+			return err
+		}
+		next[k.id] = k
+	}
+	if _, ok := next[current]; !ok {
+		return ErrUnknownKey
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	retireAt := now.Add(r.gracePeriod)
+	for id, old := range r.keys {
+		k, ok := next[id]
+		if !ok {
+			if old.retired(now) {
+				continue
+			}
+			if old.retireAt.IsZero() || old.retireAt.After(retireAt) {
+				old.retireAt = retireAt
+			}
+			next[id] = old
+			continue
+		}
+		if k.retireAt.IsZero() {
+			k.retireAt = old.retireAt
+		}
+	}
+	if old, ok := next[r.current]; ok && len(r.keys) > 0 && r.current != current && old.retireAt.IsZero() {
+		old.retireAt = retireAt
+	}
+	next[current].retireAt = time.Time{}
+
+	r.keys = next
+	r.current = current
+	return nil
+}
+
+// Rotate make the key current, the previous current key is retired
+// after the grace period, the retirement time is returned
+func (r *KeyRing) Rotate(id string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[id]
+	if !ok {
+		return time.Time{}, ErrUnknownKey
+	}
+	if k.retired(time.Now()) {
+		return time.Time{}, ErrRetiredKey
+	}
+	if id == r.current {
+		return time.Time{}, nil
+	}
+
+	retireAt := time.Now().Add(r.gracePeriod)
+	if old, ok := r.keys[r.current]; ok {
+		old.retireAt = retireAt
+	}
+	k.retireAt = time.Time{}
+	r.current = id
+	return retireAt, nil
+}
+
+// Retire set the retirement time of a key, the current key can't be retired
+func (r *KeyRing) Retire(id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if id == r.current {
+		return nil
+	}
+	k.retireAt = at
+	return nil
+}
+
+// Current the id of the signing key
+func (r *KeyRing) Current() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// CurrentPublished whether the public key of the current key is in the jwks, a symmetric key isn't
+func (r *KeyRing) CurrentPublished() bool {
+	r.mu.RLock()
+	k, ok := r.keys[r.current]
+	r.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	_, ok = publicJWK(k.id, k.method, k.verifyKey)
+	return ok
+}
+
+// Keys the state of every key, ordered by id
+func (r *KeyRing) Keys() []KeyStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	res := make([]KeyStatus, 0, len(r.keys))
+	for id, k := range r.keys {
+		status := KeyStatus{
+			ID:     id,
+			Alg:    k.method.Alg(),
+			Status: KeyActive,
+		}
+		switch {
+		case id == r.current:
+			status.Status = KeyCurrent
+		case k.retired(now):
+			status.Status = KeyRetired
+		}
+		if !k.retireAt.IsZero() {
+			retireAt := k.retireAt
+			status.RetireAt = &retireAt
+		}
+		res = append(res, status)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Token sign with the current key
+func (r *KeyRing) Token(ctx context.Context, data *jwts.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	r.mu.RLock()
+	k, ok := r.keys[r.current]
+	r.mu.RUnlock()
+	if !ok {
+		return "", "", ErrNoKey
+	}
+
+	access, err := signToken(k.id, k.method, k.signKey, r.accessClaims(data))
+	if err != nil {
+		return "", "", err
+	}
+	refresh := ""
+
+	if isGenRefresh {
+		refresh = refreshToken(access)
+	}
+
+	return access, refresh, nil
+}
+
+// Sign sign the claims with the current key, it signs the id token
+func (r *KeyRing) Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	k, ok := r.keys[r.current]
+	r.mu.RUnlock()
+	if !ok {
+		return "", ErrNoKey
+	}
+	return signToken(k.id, k.method, k.signKey, claims)
+}
+
+// Verify Verify token with the key named by its kid, retired keys are rejected
+func (r *KeyRing) Verify(ctx context.Context, ssoToken string) map[string]interface{} {
+	parts := strings.Split(ssoToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	header := make(map[string]interface{})
+	buf, err := jwt.DecodeSegment(parts[0])
+	if err != nil || json.Unmarshal(buf, &header) != nil {
+		return nil
+	}
+	id, _ := header[kid].(string)
+
+	r.mu.RLock()
+	k, ok := r.keys[id]
+	ok = ok && !k.retired(time.Now())
+	r.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if alg, _ := header["alg"].(string); alg != k.method.Alg() {
+		return nil
+	}
+	return r.check(verifyToken(ssoToken, k.method, k.verifyKey))
+}
+
+// PublicKeys the public keys which aren't retired, nothing for HS methods
+func (r *KeyRing) PublicKeys(ctx context.Context) ([]jwts.JSONWebKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	res := make([]jwts.JSONWebKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		if k.retired(now) {
+			continue
+		}
+		if jwk, ok := publicJWK(k.id, k.method, k.verifyKey); ok {
+			res = append(res, jwk)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Kid < res[j].Kid
+	})
+	return res, nil
+}
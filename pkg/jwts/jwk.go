@@ -0,0 +1,33 @@
+package jwts
+
+import "context"
+
+type (
+	// JSONWebKey public key in the RFC 7517 format
+	JSONWebKey struct {
+		Kty string `json:"kty"`
+		Use string `json:"use,omitempty"`
+		Kid string `json:"kid,omitempty"`
+		Alg string `json:"alg,omitempty"`
+
+		// RSA
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+
+		// EC
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	// JSONWebKeySet set of public keys in the RFC 7517 format
+	JSONWebKeySet struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+
+	// KeyPublisher publish the public keys which verify the access tokens,
+	// symmetric keys are never published
+	KeyPublisher interface {
+		PublicKeys(ctx context.Context) ([]JSONWebKey, error)
+	}
+)
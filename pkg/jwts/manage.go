@@ -7,11 +7,20 @@ import (
 // Manager authorization management interface
 type Manager interface {
 
+	// GenerateAuthToken the authorization code
+	GenerateAuthToken(ctx context.Context, tgr *TokenGenerateRequest) (authToken TokenInfo, err error)
+
+	// ConsumeAuthorizationCode exchange the authorization code, the user and scope of the code are filled to the request
+	ConsumeAuthorizationCode(ctx context.Context, tgr *TokenGenerateRequest) (err error)
+
 	// GenerateAccessToken the access token
-	GenerateAccessToken(ctx context.Context, jti string, otherInfo map[string]string) (accessToken TokenInfo, err error)
+	GenerateAccessToken(ctx context.Context, tgr *TokenGenerateRequest) (accessToken TokenInfo, err error)
 
 	// RefreshAccessToken an access token
-	RefreshAccessToken(ctx context.Context, refresh string) (accessToken TokenInfo, err error)
+	RefreshAccessToken(ctx context.Context, tgr *TokenGenerateRequest) (accessToken TokenInfo, err error)
+
+	// GetClient get the client information
+	GetClient(ctx context.Context, clientID string) (cli ClientInfo, err error)
 
 	// RemoveAccessToken use the access token to delete the token information
 	RemoveAccessToken(ctx context.Context, access string) (err error)
@@ -19,6 +28,15 @@ type Manager interface {
 	// RemoveRefreshToken use the refresh token to delete the token information
 	RemoveRefreshToken(ctx context.Context, refresh string) (err error)
 
+	// RevokeToken revoke the session of an access or a refresh token
+	RevokeToken(ctx context.Context, token, clientID string) (err error)
+
+	// GetSessions the live sessions of the user, the session id is the family id
+	GetSessions(ctx context.Context, userID string) (sessions []TokenInfo, err error)
+
+	// RevokeSession revoke one session of the user
+	RevokeSession(ctx context.Context, userID, sessionID string) (err error)
+
 	// LoadAccessToken according to the access token for corresponding token information
 	LoadAccessToken(ctx context.Context, access string) (ti TokenInfo, err error)
 
@@ -29,4 +47,7 @@ type Manager interface {
 	VerifyToken(ctx context.Context, refresh string) (map[string]interface{}, error)
 	//RemoveToken use the jti  to delete the token  information
 	RemoveToken(ctx context.Context, jti string) (err error)
+
+	// PublicKeys the public keys which verify the access tokens
+	PublicKeys(ctx context.Context) ([]JSONWebKey, error)
 }
@@ -28,8 +28,30 @@ type RefreshingConfig struct {
 	IsRemoveRefreshing bool
 }
 
+// SessionLimitPolicy what a login over the session limit does
+type SessionLimitPolicy string
+
+// session limit policies
+const (
+	// RejectNewSession the login is rejected
+	RejectNewSession SessionLimitPolicy = "reject"
+	// EvictOldestSession the oldest sessions are revoked to make room for the login
+	EvictOldestSession SessionLimitPolicy = "evictOldest"
+)
+
+// SessionLimit concurrent sessions of a user
+type SessionLimit struct {
+	// max sessions of a user, 0 means no limit
+	MaxSessions int
+	// policy over the limit, evict the oldest session when empty
+	Policy SessionLimitPolicy
+	// whether every login type has its own limit, e.g. one web plus one mobile session
+	PerLoginType bool
+}
+
 // default configs
 var (
+	DefaultCodeExp         = time.Minute * 10
 	DefaultTokenCfg        = &Config{AccessTokenExp: time.Hour * 2, RefreshTokenExp: time.Hour * 24 * 3, IsGenerateRefresh: true}
 	DefaultRefreshTokenCfg = &RefreshingConfig{IsGenerateRefresh: true, IsRemoveAccess: true, IsRemoveRefreshing: true}
 )
@@ -2,10 +2,13 @@ package manage
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
+	"github.com/google/uuid"
 	"github.com/quanxiang-cloud/warden/pkg/jwts"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/models"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -18,14 +21,168 @@ func NewDefaultManager() *Manager {
 
 // NewManager create to  management instance
 func NewManager() *Manager {
-	return &Manager{}
+	return &Manager{
+		gcfg:         DefaultTokenCfg,
+		rcfg:         DefaultRefreshTokenCfg,
+		loginTypeCfg: make(map[string]*Config),
+		clientCfg:    make(map[string]*Config),
+		tenantLimits: make(map[string]*SessionLimit),
+	}
 }
 
 // Manager provide management
 type Manager struct {
 	codeExp        time.Duration
+	gcfg           *Config
+	rcfg           *RefreshingConfig
+	loginTypeCfg   map[string]*Config
+	clientCfg      map[string]*Config
+	sessionLimit   *SessionLimit
+	tenantLimits   map[string]*SessionLimit
 	tokenStore     jwts.TokenStore
+	clientStore    jwts.ClientStore
 	accessGenerate jwts.AccessGenerate
+	eventHandler   jwts.EventHandler
+}
+
+// SetEventHandler set the handler of the security events
+func (m *Manager) SetEventHandler(handler jwts.EventHandler) {
+	m.eventHandler = handler
+}
+
+func (m *Manager) emit(ctx context.Context, e *jwts.Event) {
+	if m.eventHandler != nil {
+		m.eventHandler(ctx, e)
+	}
+}
+
+// SetTokenConfig set the token config
+func (m *Manager) SetTokenConfig(cfg *Config) {
+	m.gcfg = cfg
+}
+
+// SetRefreshTokenConfig set the refreshing token config
+func (m *Manager) SetRefreshTokenConfig(cfg *RefreshingConfig) {
+	m.rcfg = cfg
+}
+
+// SetLoginTypeTokenConfig set the token config of a login type, its non-zero lifetimes override the token config
+func (m *Manager) SetLoginTypeTokenConfig(loginType string, cfg *Config) {
+	m.loginTypeCfg[loginType] = cfg
+}
+
+// SetClientTokenConfig set the token config of a client, its non-zero lifetimes override the login type token config
+func (m *Manager) SetClientTokenConfig(clientID string, cfg *Config) {
+	m.clientCfg[clientID] = cfg
+}
+
+// overrideTokenConfig the token config with the login type and then the client config merged
+// over it field by field, a zero field keeps the value below it. nil if neither has one
+func (m *Manager) overrideTokenConfig(loginType, clientID string) *Config {
+	var overrides []*Config
+	if cfg, ok := m.loginTypeCfg[loginType]; ok && loginType != "" {
+		overrides = append(overrides, cfg)
+	}
+	if cfg, ok := m.clientCfg[clientID]; ok && clientID != "" {
+		overrides = append(overrides, cfg)
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	cfg := *m.gcfg
+	for _, v := range overrides {
+		if v.AccessTokenExp > 0 {
+			cfg.AccessTokenExp = v.AccessTokenExp
+		}
+		if v.RefreshTokenExp > 0 {
+			cfg.RefreshTokenExp = v.RefreshTokenExp
+		}
+	}
+	return &cfg
+}
+
+// tokenConfig the token config of the request,
+// no refresh token is issued for the client credentials grant
+func (m *Manager) tokenConfig(tgr *jwts.TokenGenerateRequest) *Config {
+	cfg := m.gcfg
+	if v := m.overrideTokenConfig(tgr.LoginType, tgr.ClientID); v != nil {
+		cfg = v
+	}
+	if tgr.GrantType == jwts.ClientCredentials && cfg.IsGenerateRefresh {
+		c := *cfg
+		c.IsGenerateRefresh = false
+		return &c
+	}
+	return cfg
+}
+
+// SetSessionLimit set the concurrent session limit of the users
+func (m *Manager) SetSessionLimit(limit *SessionLimit) {
+	m.sessionLimit = limit
+}
+
+// SetTenantSessionLimit set the concurrent session limit of the users of a tenant, it overrides the session limit
+func (m *Manager) SetTenantSessionLimit(tenantID string, limit *SessionLimit) {
+	m.tenantLimits[tenantID] = limit
+}
+
+// limitSessions make room for the session of the request, or reject it by the policy
+func (m *Manager) limitSessions(ctx context.Context, tgr *jwts.TokenGenerateRequest) error {
+	limit := m.sessionLimit
+	if v, ok := m.tenantLimits[tgr.TenantID]; ok && tgr.TenantID != "" {
+		limit = v
+	}
+	if limit == nil || limit.MaxSessions <= 0 || tgr.UserID == "" || tgr.GrantType == jwts.ClientCredentials {
+		return nil
+	}
+
+	all, err := m.GetSessions(ctx, tgr.UserID)
+	if err != nil {
+		return err
+	}
+	sessions := make([]jwts.TokenInfo, 0, len(all))
+	for _, ti := range all {
+		if !limit.PerLoginType || ti.GetLoginType() == tgr.LoginType {
+			sessions = append(sessions, ti)
+		}
+	}
+	over := len(sessions) - limit.MaxSessions + 1
+	if over <= 0 {
+		return nil
+	}
+
+	if limit.Policy == RejectNewSession {
+		m.emit(ctx, &jwts.Event{
+			Type:   jwts.EventSessionLimitRejected,
+			UserID: tgr.UserID,
+			Time:   time.Now(),
+		})
+		return errors.ErrSessionLimitExceeded
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessionCreateAt(sessions[i]).Before(sessionCreateAt(sessions[j]))
+	})
+	for _, ti := range sessions[:over] {
+		if err := m.tokenStore.RemoveFamily(ctx, tgr.UserID, ti.GetFamilyID()); err != nil {
+			return err
+		}
+		m.emit(ctx, &jwts.Event{
+			Type:     jwts.EventSessionEvicted,
+			UserID:   tgr.UserID,
+			FamilyID: ti.GetFamilyID(),
+			Time:     time.Now(),
+		})
+	}
+	return nil
+}
+
+// sessionCreateAt the login time, tokens issued before it was recorded fall back to the refresh token
+func sessionCreateAt(ti jwts.TokenInfo) time.Time {
+	if v := ti.GetCreateAt(); !v.IsZero() {
+		return v
+	}
+	return ti.GetRefreshCreateAt()
 }
 
 // SetCodeExp set the  code expiration time
@@ -43,6 +200,25 @@ func (m *Manager) MapTokenStorage(stor jwts.TokenStore) {
 	m.tokenStore = stor
 }
 
+// MapClientStorage mapping the client store interface
+func (m *Manager) MapClientStorage(stor jwts.ClientStore) {
+	m.clientStore = stor
+}
+
+// GetClient get the client information
+func (m *Manager) GetClient(ctx context.Context, clientID string) (jwts.ClientInfo, error) {
+	if m.clientStore == nil {
+		return nil, errors.ErrInvalidClient
+	}
+	cli, err := m.clientStore.GetByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	} else if cli == nil {
+		return nil, errors.ErrInvalidClient
+	}
+	return cli, nil
+}
+
 // MustTokenStorage mandatory mapping the token store interface
 func (m *Manager) MustTokenStorage(stor jwts.TokenStore, err error) {
 	if err != nil {
@@ -51,17 +227,98 @@ func (m *Manager) MustTokenStorage(stor jwts.TokenStore, err error) {
 	m.tokenStore = stor
 }
 
-// GenerateAccessToken generate the access token
-func (m *Manager) GenerateAccessToken(ctx context.Context, jti string, otherInfo map[string]string) (jwts.TokenInfo, error) {
+// GenerateAuthToken generate the authorization code, it is single use
+// and bound to the client, the redirect URI and the PKCE challenge
+func (m *Manager) GenerateAuthToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
+	ti := models.NewToken()
+	ti.SetUserID(tgr.UserID)
+	ti.SetClientID(tgr.ClientID)
+	ti.SetLoginType(tgr.LoginType)
+	ti.SetScope(tgr.Scope)
+	ti.SetRedirectURI(tgr.RedirectURI)
+	ti.SetNonce(tgr.Nonce)
+	ti.SetCodeChallenge(tgr.CodeChallenge)
+	ti.SetCodeChallengeMethod(tgr.CodeChallengeMethod)
+
+	codeExp := m.codeExp
+	if codeExp == 0 {
+		codeExp = DefaultCodeExp
+	}
+	ti.SetCode(newCode())
+	ti.SetCodeCreateAt(time.Now())
+	ti.SetCodeExpiresIn(codeExp)
+
+	if err := m.tokenStore.Create(ctx, ti); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+// ConsumeAuthorizationCode exchange the authorization code, the user and the scope
+// of the code are filled to the request. The code is taken from the store at once
+// before it is checked, so only one request can redeem it and it can't be tried twice.
+func (m *Manager) ConsumeAuthorizationCode(ctx context.Context, tgr *jwts.TokenGenerateRequest) error {
+	if tgr.Code == "" {
+		return errors.ErrInvalidAuthorizeCode
+	}
+	ti, err := m.tokenStore.TakeByCode(ctx, tgr.Code)
+	if err != nil {
+		return err
+	} else if ti == nil || ti.GetCode() != tgr.Code ||
+		ti.GetCodeCreateAt().Add(ti.GetCodeExpiresIn()).Before(time.Now()) {
+		return errors.ErrInvalidAuthorizeCode
+	}
+
+	if ti.GetClientID() != tgr.ClientID || ti.GetRedirectURI() != tgr.RedirectURI {
+		return errors.ErrInvalidAuthorizeCode
+	}
+	if cc := ti.GetCodeChallenge(); cc != "" {
+		if tgr.CodeVerifier == "" {
+			return errors.ErrMissingCodeVerifier
+		}
+		if !ti.GetCodeChallengeMethod().Verify(cc, tgr.CodeVerifier) {
+			return errors.ErrInvalidCodeChallenge
+		}
+	}
+
+	tgr.UserID = ti.GetUserID()
+	tgr.LoginType = ti.GetLoginType()
+	tgr.Scope = ti.GetScope()
+	tgr.Nonce = ti.GetNonce()
+	return nil
+}
+
+// newCode a random authorization code
+func newCode() string {
+	code := uuid.NewSHA1(uuid.Must(uuid.NewRandom()), []byte(newJti())).String()
+	code = base64.URLEncoding.EncodeToString([]byte(code))
+	return strings.ToUpper(strings.TrimRight(code, "="))
+}
+
+// GenerateAccessToken generate the access token, the session limit of the user is enforced
+func (m *Manager) GenerateAccessToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
+	if err := m.limitSessions(ctx, tgr); err != nil {
+		return nil, err
+	}
 
 	ti := models.NewToken()
-	ti.SetUserID(jti)
+	ti.SetUserID(tgr.UserID)
+	ti.SetFamilyID(uuid.Must(uuid.NewRandom()).String())
+	ti.SetLoginType(tgr.LoginType)
+	ti.SetClientID(tgr.ClientID)
+	ti.SetTenantID(tgr.TenantID)
+	ti.SetUserName(tgr.UserName)
+	ti.SetDepartmentIDs(tgr.DepartmentIDs)
+	ti.SetScope(tgr.Scope)
+	ti.SetNonce(tgr.Nonce)
+	setDevice(ti, tgr)
 
 	createAt := time.Now()
+	ti.SetCreateAt(createAt)
 	ti.SetAccessCreateAt(createAt)
 
 	// set access token expires
-	gcfg := DefaultTokenCfg
+	gcfg := m.tokenConfig(tgr)
 	aexp := gcfg.AccessTokenExp
 
 	ti.SetAccessExpiresIn(aexp)
@@ -71,14 +328,13 @@ func (m *Manager) GenerateAccessToken(ctx context.Context, jti string, otherInfo
 	}
 
 	td := &jwts.GenerateBasic{
-		Jti:       jti,
+		UserID:    tgr.UserID,
+		Jti:       newJti(),
 		CreateAt:  createAt,
 		TokenInfo: ti,
 	}
-	if otherInfo != nil {
-		ti.OtherInfo = otherInfo
-		bytes, _ := json.Marshal(otherInfo)
-		td.OtherInfo = string(bytes)
+	if tgr.OtherInfo != nil {
+		ti.OtherInfo = tgr.OtherInfo
 	}
 
 	av, rv, err := m.accessGenerate.Token(ctx, td, gcfg.IsGenerateRefresh)
@@ -99,37 +355,93 @@ func (m *Manager) GenerateAccessToken(ctx context.Context, jti string, otherInfo
 	return ti, nil
 }
 
-// RefreshAccessToken refreshing an access token
-func (m *Manager) RefreshAccessToken(ctx context.Context, refesh string) (jwts.TokenInfo, error) {
+// setDevice the device of the request, a refresh without it keeps the device of the login
+func setDevice(ti jwts.TokenInfo, tgr *jwts.TokenGenerateRequest) {
+	if tgr.ClientIP != "" {
+		ti.SetClientIP(tgr.ClientIP)
+	}
+	if tgr.UserAgent != "" {
+		ti.SetUserAgent(tgr.UserAgent)
+		ti.SetDeviceName(tgr.DeviceName)
+	}
+}
+
+// newJti a random id of the token
+func newJti() string {
+	return uuid.Must(uuid.NewRandom()).String()
+}
 
-	ti, err := m.LoadRefreshToken(ctx, refesh)
-	if err != nil {
+// RefreshAccessToken refreshing an access token,
+// a refresh token issued to a client can only be refreshed by the client,
+// the first-party tokens only without a client, and the scope can only be narrowed
+func (m *Manager) RefreshAccessToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
+
+	ti, err := m.LoadRefreshToken(ctx, tgr.Refresh)
+	if err == errors.ErrInvalidRefreshToken {
+		return nil, m.checkReusedRefreshToken(ctx, tgr.Refresh)
+	} else if err != nil {
 		return nil, err
 	}
+	if ti.GetClientID() != tgr.ClientID {
+		return nil, errors.ErrInvalidRefreshToken
+	}
+	if scope := tgr.Scope; scope != "" {
+		if !jwts.ContainsScope(ti.GetScope(), scope) {
+			return nil, errors.ErrInvalidScope
+		}
+		ti.SetScope(scope)
+	}
+
+	setDevice(ti, tgr)
 
 	oldAccess, oldRefresh := ti.GetAccess(), ti.GetRefresh()
+	rotated := models.NewToken()
+	rotated.SetUserID(ti.GetUserID())
+	rotated.SetFamilyID(ti.GetFamilyID())
 
 	td := &jwts.GenerateBasic{
-		Jti:       ti.GetUserID(),
+		UserID:    ti.GetUserID(),
+		Jti:       newJti(),
 		CreateAt:  time.Now(),
 		TokenInfo: ti,
 	}
 
-	rcfg := DefaultRefreshTokenCfg
+	rcfg := m.rcfg
+	aexp, rexp := rcfg.AccessTokenExp, rcfg.RefreshTokenExp
+	if cfg := m.overrideTokenConfig(ti.GetLoginType(), ti.GetClientID()); cfg != nil {
+		aexp, rexp = cfg.AccessTokenExp, cfg.RefreshTokenExp
+	}
 
 	ti.SetAccessCreateAt(td.CreateAt)
-	if v := rcfg.AccessTokenExp; v > 0 {
-		ti.SetAccessExpiresIn(v)
+	ti.SetLastRefreshAt(td.CreateAt)
+	if aexp > 0 {
+		ti.SetAccessExpiresIn(aexp)
 	}
 
-	if v := rcfg.RefreshTokenExp; v > 0 {
-		ti.SetRefreshExpiresIn(v)
+	if rexp > 0 {
+		ti.SetRefreshExpiresIn(rexp)
 	}
 
 	if rcfg.IsResetRefreshTime {
 		ti.SetRefreshCreateAt(td.CreateAt)
 	}
 
+	if rcfg.IsGenerateRefresh {
+		// the old refresh token is claimed before the new tokens are issued, so a concurrent
+		// refresh with it is a reuse. It is remembered for the lifetime of the family,
+		// presenting it again revokes the whole family
+		exp := ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn()).Sub(time.Now())
+		if ti.GetRefreshExpiresIn() == 0 {
+			exp = 0
+		}
+		claimed, err := m.tokenStore.MarkRotated(ctx, oldRefresh, rotated, exp)
+		if err != nil {
+			return nil, err
+		} else if !claimed {
+			return nil, m.checkReusedRefreshToken(ctx, oldRefresh)
+		}
+	}
+
 	tv, rv, err := m.accessGenerate.Token(ctx, td, rcfg.IsGenerateRefresh)
 	if err != nil {
 		return nil, err
@@ -144,6 +456,19 @@ func (m *Manager) RefreshAccessToken(ctx context.Context, refesh string) (jwts.T
 		return nil, err
 	}
 
+	if rcfg.IsGenerateRefresh {
+		// the family has been revoked by a reuse between the claim and the create,
+		// the new tokens are revoked with it
+		if old, err := m.tokenStore.GetByRefresh(ctx, oldRefresh); err != nil {
+			return nil, err
+		} else if old == nil {
+			if err := m.tokenStore.RemoveFamily(ctx, ti.GetUserID(), ti.GetFamilyID()); err != nil {
+				return nil, err
+			}
+			return nil, errors.ErrReusedRefreshToken
+		}
+	}
+
 	if rcfg.IsRemoveAccess {
 		// remove the old access token
 		if err := m.tokenStore.RemoveByAccess(ctx, oldAccess); err != nil {
@@ -167,6 +492,27 @@ func (m *Manager) RefreshAccessToken(ctx context.Context, refesh string) (jwts.T
 	return ti, nil
 }
 
+// checkReusedRefreshToken revoke the family when the refresh token has already been rotated
+func (m *Manager) checkReusedRefreshToken(ctx context.Context, refresh string) error {
+	ti, err := m.tokenStore.GetRotated(ctx, refresh)
+	if err != nil {
+		return err
+	} else if ti == nil || ti.GetFamilyID() == "" {
+		return errors.ErrInvalidRefreshToken
+	}
+
+	if err = m.tokenStore.RemoveFamily(ctx, ti.GetUserID(), ti.GetFamilyID()); err != nil {
+		return err
+	}
+	m.emit(ctx, &jwts.Event{
+		Type:     jwts.EventRefreshTokenReused,
+		UserID:   ti.GetUserID(),
+		FamilyID: ti.GetFamilyID(),
+		Time:     time.Now(),
+	})
+	return errors.ErrReusedRefreshToken
+}
+
 // RemoveAccessToken use the access token to delete the token information
 func (m *Manager) RemoveAccessToken(ctx context.Context, access string) error {
 	if access == "" {
@@ -183,6 +529,80 @@ func (m *Manager) RemoveRefreshToken(ctx context.Context, refresh string) error
 	return m.tokenStore.RemoveByRefresh(ctx, refresh)
 }
 
+// RevokeToken revoke the session of an access or a refresh token, an unknown token is no error.
+// When clientID is given the token must have been issued to the client (RFC 7009 2.1),
+// the sessions of /login have no client and are refused
+func (m *Manager) RevokeToken(ctx context.Context, token, clientID string) error {
+	if token == "" {
+		return nil
+	}
+	ti, err := m.tokenStore.GetByAccess(ctx, token)
+	if err != nil {
+		return err
+	} else if ti == nil || ti.GetAccess() != token {
+		if ti, err = m.tokenStore.GetByRefresh(ctx, token); err != nil {
+			return err
+		} else if ti == nil || ti.GetRefresh() != token {
+			return nil
+		}
+	}
+	if clientID != "" && ti.GetClientID() != clientID {
+		return errors.ErrUnauthorizedClient
+	}
+	return m.tokenStore.RemoveByToken(ctx, token)
+}
+
+// GetSessions the live sessions of the user, one token information per family
+func (m *Manager) GetSessions(ctx context.Context, userID string) ([]jwts.TokenInfo, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	infos, err := m.tokenStore.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ct := time.Now()
+	families := make(map[string]int, len(infos))
+	sessions := make([]jwts.TokenInfo, 0, len(infos))
+	for _, ti := range infos {
+		if ti.GetFamilyID() == "" || sessionExpired(ti, ct) {
+			continue
+		}
+		// a family has two token informations for the moment it is being refreshed
+		if i, ok := families[ti.GetFamilyID()]; ok {
+			if ti.GetAccessCreateAt().After(sessions[i].GetAccessCreateAt()) {
+				sessions[i] = ti
+			}
+			continue
+		}
+		families[ti.GetFamilyID()] = len(sessions)
+		sessions = append(sessions, ti)
+	}
+	return sessions, nil
+}
+
+// sessionExpired the session lives as long as the refresh token, or the access token without one
+func sessionExpired(ti jwts.TokenInfo, ct time.Time) bool {
+	if ti.GetRefresh() != "" {
+		return ti.GetRefreshExpiresIn() != 0 && ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn()).Before(ct)
+	}
+	return ti.GetAccessExpiresIn() != 0 && ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Before(ct)
+}
+
+// RevokeSession revoke one session of the user, the others are kept
+func (m *Manager) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	sessions, err := m.GetSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, ti := range sessions {
+		if ti.GetFamilyID() == sessionID {
+			return m.tokenStore.RemoveFamily(ctx, userID, sessionID)
+		}
+	}
+	return errors.ErrSessionNotFound
+}
+
 // LoadAccessToken according to the access token for corresponding token information
 func (m *Manager) LoadAccessToken(ctx context.Context, access string) (jwts.TokenInfo, error) {
 	if access == "" {
@@ -242,3 +662,12 @@ func (m *Manager) VerifyToken(ctx context.Context, ssoToken string) (map[string]
 func (m *Manager) RemoveToken(c context.Context, jti string) (err error) {
 	return m.tokenStore.RemoveToken(c, jti)
 }
+
+// PublicKeys the public keys which verify the access tokens,
+// empty when the access generate doesn't publish its keys
+func (m *Manager) PublicKeys(ctx context.Context) ([]jwts.JSONWebKey, error) {
+	if p, ok := m.accessGenerate.(jwts.KeyPublisher); ok {
+		return p.PublicKeys(ctx)
+	}
+	return nil, nil
+}
@@ -26,6 +26,49 @@ type (
 		GetRefreshExpiresIn() time.Duration
 		SetRefreshExpiresIn(time.Duration)
 
+		GetFamilyID() string
+		SetFamilyID(string)
+		GetCreateAt() time.Time
+		SetCreateAt(time.Time)
+		GetLastRefreshAt() time.Time
+		SetLastRefreshAt(time.Time)
+
+		GetLoginType() string
+		SetLoginType(string)
+		GetClientID() string
+		SetClientID(string)
+
+		GetTenantID() string
+		SetTenantID(string)
+		GetUserName() string
+		SetUserName(string)
+		GetDepartmentIDs() [][]string
+		SetDepartmentIDs([][]string)
+		GetScope() string
+		SetScope(string)
+
+		GetClientIP() string
+		SetClientIP(string)
+		GetUserAgent() string
+		SetUserAgent(string)
+		GetDeviceName() string
+		SetDeviceName(string)
+		GetRedirectURI() string
+		SetRedirectURI(string)
+		GetNonce() string
+		SetNonce(string)
+
+		GetCode() string
+		SetCode(string)
+		GetCodeCreateAt() time.Time
+		SetCodeCreateAt(time.Time)
+		GetCodeExpiresIn() time.Duration
+		SetCodeExpiresIn(time.Duration)
+		GetCodeChallenge() string
+		SetCodeChallenge(string)
+		GetCodeChallengeMethod() CodeChallengeMethod
+		SetCodeChallengeMethod(CodeChallengeMethod)
+
 		GetOtherInfo() map[string]string
 		SetOtherInfo(map[string]string)
 	}
@@ -0,0 +1,50 @@
+package models
+
+import "github.com/quanxiang-cloud/warden/pkg/jwts"
+
+// Client client model, a client without secret is a public client
+type Client struct {
+	ID         string
+	Secret     string
+	Domain     string
+	UserID     string
+	GrantTypes []jwts.GrantType
+	Scopes     []string
+	// RedirectURIs allowlist of the authorization code flow
+	RedirectURIs []string
+}
+
+// GetID client id
+func (c *Client) GetID() string {
+	return c.ID
+}
+
+// GetSecret client secret
+func (c *Client) GetSecret() string {
+	return c.Secret
+}
+
+// GetDomain client domain
+func (c *Client) GetDomain() string {
+	return c.Domain
+}
+
+// GetUserID user id of the client, the subject of its client credentials tokens
+func (c *Client) GetUserID() string {
+	return c.UserID
+}
+
+// GetGrantTypes the grant types the client may use
+func (c *Client) GetGrantTypes() []jwts.GrantType {
+	return c.GrantTypes
+}
+
+// GetScopes the scopes the client may request
+func (c *Client) GetScopes() []string {
+	return c.Scopes
+}
+
+// GetRedirectURIs the redirect URIs allowed for the authorization code flow
+func (c *Client) GetRedirectURIs() []string {
+	return c.RedirectURIs
+}
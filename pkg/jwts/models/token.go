@@ -19,6 +19,26 @@ type Token struct {
 	Refresh          string        `bson:"Refresh"`
 	RefreshCreateAt  time.Time     `bson:"RefreshCreateAt"`
 	RefreshExpiresIn time.Duration `bson:"RefreshExpiresIn"`
+	FamilyID         string        `bson:"FamilyID"`
+	CreateAt         time.Time     `bson:"CreateAt"`
+	LastRefreshAt    time.Time     `bson:"LastRefreshAt"`
+	LoginType        string        `bson:"LoginType"`
+	ClientID         string        `bson:"ClientID"`
+	TenantID         string        `bson:"TenantID"`
+	UserName         string        `bson:"UserName"`
+	DepartmentIDs    [][]string    `bson:"DepartmentIDs"`
+	Scope            string        `bson:"Scope"`
+	ClientIP         string        `bson:"ClientIP"`
+	UserAgent        string        `bson:"UserAgent"`
+	DeviceName       string        `bson:"DeviceName"`
+	RedirectURI      string        `bson:"RedirectURI"`
+	Nonce            string        `bson:"Nonce"`
+
+	Code                string        `bson:"Code"`
+	CodeCreateAt        time.Time     `bson:"CodeCreateAt"`
+	CodeExpiresIn       time.Duration `bson:"CodeExpiresIn"`
+	CodeChallenge       string        `bson:"CodeChallenge"`
+	CodeChallengeMethod string        `bson:"CodeChallengeMethod"`
 
 	OtherInfo map[string]string `bson:"OtherInfo"`
 }
@@ -98,6 +118,196 @@ func (t *Token) SetRefreshExpiresIn(exp time.Duration) {
 	t.RefreshExpiresIn = exp
 }
 
+// GetFamilyID the family of the refresh tokens rotated from one login
+func (t *Token) GetFamilyID() string {
+	return t.FamilyID
+}
+
+// SetFamilyID the family of the refresh tokens rotated from one login
+func (t *Token) SetFamilyID(familyID string) {
+	t.FamilyID = familyID
+}
+
+// GetCreateAt the login time of the family
+func (t *Token) GetCreateAt() time.Time {
+	return t.CreateAt
+}
+
+// SetCreateAt the login time of the family
+func (t *Token) SetCreateAt(createAt time.Time) {
+	t.CreateAt = createAt
+}
+
+// GetLastRefreshAt the time the family was refreshed last, zero if it never was
+func (t *Token) GetLastRefreshAt() time.Time {
+	return t.LastRefreshAt
+}
+
+// SetLastRefreshAt the time the family was refreshed last, zero if it never was
+func (t *Token) SetLastRefreshAt(refreshAt time.Time) {
+	t.LastRefreshAt = refreshAt
+}
+
+// GetLoginType the login type the token was issued for
+func (t *Token) GetLoginType() string {
+	return t.LoginType
+}
+
+// SetLoginType the login type the token was issued for
+func (t *Token) SetLoginType(loginType string) {
+	t.LoginType = loginType
+}
+
+// GetClientID the client the token was issued to
+func (t *Token) GetClientID() string {
+	return t.ClientID
+}
+
+// SetClientID the client the token was issued to
+func (t *Token) SetClientID(clientID string) {
+	t.ClientID = clientID
+}
+
+// GetTenantID the tenant claim of the token
+func (t *Token) GetTenantID() string {
+	return t.TenantID
+}
+
+// SetTenantID the tenant claim of the token
+func (t *Token) SetTenantID(tenantID string) {
+	t.TenantID = tenantID
+}
+
+// GetUserName the name claim of the token
+func (t *Token) GetUserName() string {
+	return t.UserName
+}
+
+// SetUserName the name claim of the token
+func (t *Token) SetUserName(name string) {
+	t.UserName = name
+}
+
+// GetDepartmentIDs the department paths claim of the token
+func (t *Token) GetDepartmentIDs() [][]string {
+	return t.DepartmentIDs
+}
+
+// SetDepartmentIDs the department paths claim of the token
+func (t *Token) SetDepartmentIDs(depIDs [][]string) {
+	t.DepartmentIDs = depIDs
+}
+
+// GetClientIP the address the session was logged in or refreshed from last
+func (t *Token) GetClientIP() string {
+	return t.ClientIP
+}
+
+// SetClientIP the address the session was logged in or refreshed from last
+func (t *Token) SetClientIP(ip string) {
+	t.ClientIP = ip
+}
+
+// GetUserAgent the user agent of the session
+func (t *Token) GetUserAgent() string {
+	return t.UserAgent
+}
+
+// SetUserAgent the user agent of the session
+func (t *Token) SetUserAgent(userAgent string) {
+	t.UserAgent = userAgent
+}
+
+// GetDeviceName the device name parsed from the user agent
+func (t *Token) GetDeviceName() string {
+	return t.DeviceName
+}
+
+// SetDeviceName the device name parsed from the user agent
+func (t *Token) SetDeviceName(name string) {
+	t.DeviceName = name
+}
+
+// GetScope the space separated scopes of the token
+func (t *Token) GetScope() string {
+	return t.Scope
+}
+
+// SetScope the space separated scopes of the token
+func (t *Token) SetScope(scope string) {
+	t.Scope = scope
+}
+
+// GetRedirectURI redirect URI of the authorization code
+func (t *Token) GetRedirectURI() string {
+	return t.RedirectURI
+}
+
+// SetRedirectURI redirect URI of the authorization code
+func (t *Token) SetRedirectURI(redirectURI string) {
+	t.RedirectURI = redirectURI
+}
+
+// GetNonce the nonce of the id token
+func (t *Token) GetNonce() string {
+	return t.Nonce
+}
+
+// SetNonce the nonce of the id token
+func (t *Token) SetNonce(nonce string) {
+	t.Nonce = nonce
+}
+
+// GetCode authorization code
+func (t *Token) GetCode() string {
+	return t.Code
+}
+
+// SetCode authorization code
+func (t *Token) SetCode(code string) {
+	t.Code = code
+}
+
+// GetCodeCreateAt create Time
+func (t *Token) GetCodeCreateAt() time.Time {
+	return t.CodeCreateAt
+}
+
+// SetCodeCreateAt create Time
+func (t *Token) SetCodeCreateAt(createAt time.Time) {
+	t.CodeCreateAt = createAt
+}
+
+// GetCodeExpiresIn the lifetime in seconds of the authorization code
+func (t *Token) GetCodeExpiresIn() time.Duration {
+	return t.CodeExpiresIn
+}
+
+// SetCodeExpiresIn the lifetime in seconds of the authorization code
+func (t *Token) SetCodeExpiresIn(exp time.Duration) {
+	t.CodeExpiresIn = exp
+}
+
+// GetCodeChallenge challenge code
+func (t *Token) GetCodeChallenge() string {
+	return t.CodeChallenge
+}
+
+// SetCodeChallenge challenge code
+func (t *Token) SetCodeChallenge(code string) {
+	t.CodeChallenge = code
+}
+
+// GetCodeChallengeMethod challenge method
+func (t *Token) GetCodeChallengeMethod() jwts.CodeChallengeMethod {
+	return jwts.CodeChallengeMethod(t.CodeChallengeMethod)
+}
+
+// SetCodeChallengeMethod challenge method
+func (t *Token) SetCodeChallengeMethod(method jwts.CodeChallengeMethod) {
+	t.CodeChallengeMethod = string(method)
+}
+
 // GetOtherInfo GetOtherInfo
 func (t *Token) GetOtherInfo() map[string]string {
 
@@ -0,0 +1,31 @@
+package jwts
+
+// TokenGenerateRequest provide to generate the token request parameters
+type TokenGenerateRequest struct {
+	UserID    string
+	LoginType string
+	ClientID  string
+	GrantType GrantType
+	Scope     string
+	Refresh   string
+
+	// the authorization code flow
+	Code                string
+	CodeVerifier        string
+	CodeChallenge       string
+	CodeChallengeMethod CodeChallengeMethod
+	RedirectURI         string
+	Nonce               string
+
+	// the device of the session, updated on every refresh
+	ClientIP   string
+	UserAgent  string
+	DeviceName string
+
+	OtherInfo map[string]string
+
+	// the profile carried by the token claims
+	TenantID      string
+	UserName      string
+	DepartmentIDs [][]string
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// CheckResponseType check allows response type
+func (s *Server) CheckResponseType(rt jwts.ResponseType) bool {
+	for _, art := range s.Config.AllowedResponseTypes {
+		if art == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationAuthorizeRequest the authorization request validation.
+// The request is nil when the client or the redirect uri is invalid,
+// the error can't be redirected to the client then.
+func (s *Server) ValidationAuthorizeRequest(r *http.Request) (*AuthorizeRequest, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return nil, errors.ErrInvalidRequest
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.ErrInvalidRequest
+	}
+
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		return nil, errors.ErrInvalidClient
+	}
+	cli, err := s.Manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	redirectTo, err := clientRedirectURI(cli, redirectURI)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &AuthorizeRequest{
+		ResponseType:        jwts.ResponseType(r.Form.Get("response_type")),
+		ClientID:            clientID,
+		RedirectURI:         redirectURI,
+		RedirectTo:          redirectTo,
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: jwts.CodeChallengeMethod(r.Form.Get("code_challenge_method")),
+		Nonce:               r.Form.Get("nonce"),
+		Request:             r,
+	}
+
+	if req.ResponseType.String() == "" {
+		return req, errors.ErrInvalidRequest
+	} else if !s.CheckResponseType(req.ResponseType) {
+		return req, errors.ErrUnsupportedResponseType
+	}
+	if !clientGrantType(cli, jwts.AuthorizationCode) {
+		return req, errors.ErrUnauthorizedClient
+	}
+	if req.Scope, err = clientScope(cli, r.Form.Get(scope)); err != nil {
+		return req, err
+	}
+
+	// a public client can't keep a secret, so the code is bound to PKCE instead
+	if req.CodeChallenge == "" {
+		if s.Config.ForcePKCE || cli.GetSecret() == "" {
+			return req, errors.ErrMissingCodeChallenge
+		}
+		return req, nil
+	}
+	if !req.CodeChallengeMethod.Validate() {
+		return req, errors.ErrUnsupportedCodeChallengeMethod
+	}
+	return req, nil
+}
+
+// clientRedirectURI the redirect uri must be one of the client's,
+// the only one registered is used when the request has none
+func clientRedirectURI(cli jwts.ClientInfo, redirectURI string) (string, error) {
+	uris := cli.GetRedirectURIs()
+	if redirectURI == "" {
+		if len(uris) != 1 {
+			return "", errors.ErrInvalidRedirectURI
+		}
+		return uris[0], nil
+	}
+	for _, v := range uris {
+		if v == redirectURI {
+			return v, nil
+		}
+	}
+	return "", errors.ErrInvalidRedirectURI
+}
+
+// HandleAuthorizeRequest authorize request handling, the code is redirected to the client
+func (s *Server) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request) error {
+	req, err := s.ValidationAuthorizeRequest(r)
+	if err != nil {
+		if req == nil {
+			return s.tokenError(w, authorizeError(err))
+		}
+		return s.redirectError(w, req, err)
+	}
+
+	if s.UserAuthorizationHandler == nil {
+		return s.redirectError(w, req, errors.ErrAccessDenied)
+	}
+	userID, err := s.UserAuthorizationHandler(w, r)
+	if err != nil {
+		return s.redirectError(w, req, err)
+	} else if userID == "" {
+		return nil
+	}
+	req.UserID = userID
+
+	ti, err := s.Manager.GenerateAuthToken(r.Context(), &jwts.TokenGenerateRequest{
+		ClientID:            req.ClientID,
+		UserID:              req.UserID,
+		Scope:               req.Scope,
+		RedirectURI:         req.RedirectURI,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+	})
+	if err != nil {
+		return s.redirectError(w, req, err)
+	}
+	return s.redirect(w, req, map[string]interface{}{
+		"code": ti.GetCode(),
+	})
+}
+
+// redirectError the error is redirected to the client, as RFC 6749 4.1.2.1 requires
+func (s *Server) redirectError(w http.ResponseWriter, req *AuthorizeRequest, err error) error {
+	data, _, _ := s.GetErrorData(authorizeError(err))
+	return s.redirect(w, req, data)
+}
+
+// authorizeError the RFC 6749 error of the authorization request
+func authorizeError(err error) error {
+	switch err {
+	case errors.ErrInvalidRedirectURI, errors.ErrMissingCodeChallenge, errors.ErrUnsupportedCodeChallengeMethod:
+		return errors.ErrInvalidRequest
+	}
+	return err
+}
+
+func (s *Server) redirect(w http.ResponseWriter, req *AuthorizeRequest, data map[string]interface{}) error {
+	u, err := url.Parse(req.RedirectTo)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	for k, v := range data {
+		q.Set(k, fmt.Sprint(v))
+	}
+	u.RawQuery = q.Encode()
+
+	w.Header().Set("Location", u.String())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
@@ -1,22 +1,47 @@
 package server
 
 import (
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
 	"net/http"
 	"time"
 )
 
 // Config configuration parameters
 type Config struct {
+	TokenType            string              // token type
+	AllowedResponseTypes []jwts.ResponseType // allow the authorize type
+	AllowedGrantTypes    []jwts.GrantType    // allow the grant type
+	ForcePKCE            bool                // confidential clients need PKCE as well
 }
 
 // NewConfig create to configuration instance
 func NewConfig() *Config {
-	return &Config{}
+	return &Config{
+		TokenType:            "Bearer",
+		AllowedResponseTypes: []jwts.ResponseType{jwts.Code},
+		AllowedGrantTypes: []jwts.GrantType{
+			jwts.AuthorizationCode,
+			jwts.PasswordCredentials,
+			jwts.ClientCredentials,
+			jwts.Refreshing,
+		},
+	}
 }
 
 // AuthorizeRequest authorization request
 type AuthorizeRequest struct {
-	UserID         string
-	AccessTokenExp time.Duration
-	Request        *http.Request
+	ResponseType jwts.ResponseType
+	ClientID     string
+	Scope        string
+	// RedirectURI the redirect_uri of the request, the code is bound to it
+	RedirectURI string
+	// RedirectTo where the response is redirected, the registered URI when redirect_uri is empty
+	RedirectTo          string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod jwts.CodeChallengeMethod
+	Nonce               string
+	UserID              string
+	AccessTokenExp      time.Duration
+	Request             *http.Request
 }
@@ -1,14 +1,37 @@
 package server
 
 import (
+	"context"
 	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 type (
 
-	// UserAuthorizationHandler get user id from request authorization
+	// ClientInfoHandler get client info from request
+	ClientInfoHandler func(r *http.Request) (clientID, clientSecret string, err error)
+
+	// PasswordAuthorizationHandler get user id from username and password
+	PasswordAuthorizationHandler func(ctx context.Context, loginType, username, password string) (userID string, err error)
+
+	// InternalErrorHandler internal error handing, nil keeps the server error response
+	InternalErrorHandler func(err error) (re *errors.Response)
+
+	// IntrospectionHandler in response to the introspection with the extension of the field,
+	// the fields override the ones of the token information
+	IntrospectionHandler func(ctx context.Context, ti jwts.TokenInfo) (fieldsValue map[string]interface{})
+
+	// ClaimsHandler fill the claims of the token generate request
+	ClaimsHandler func(ctx context.Context, tgr *jwts.TokenGenerateRequest) error
+
+	// ClientIPHandler get the address of the client from request
+	ClientIPHandler func(r *http.Request) string
+
+	// UserAuthorizationHandler get user id from request authorization,
+	// an empty user id means the handler has responded, e.g. redirected to the login page
 	UserAuthorizationHandler func(w http.ResponseWriter, r *http.Request) (userID string, err error)
 
 	//RefreshingValidationHandler check if refresh_token is still valid. eg no revocation or other
@@ -20,3 +43,39 @@ type (
 	// ExtensionFieldsHandler in response to the access token with the extension of the field
 	ExtensionFieldsHandler func(ti jwts.TokenInfo) (fieldsValue map[string]interface{})
 )
+
+// ClientFormHandler get client data from form
+func ClientFormHandler(r *http.Request) (string, string, error) {
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		return "", "", errors.ErrInvalidClient
+	}
+	clientSecret := r.Form.Get("client_secret")
+	return clientID, clientSecret, nil
+}
+
+// ClientBasicHandler get client data from basic authorization,
+// the id and secret are form url encoded as RFC 6749 2.3.1 requires
+func ClientBasicHandler(r *http.Request) (string, string, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", "", errors.ErrInvalidClient
+	}
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", errors.ErrInvalidClient
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return "", "", errors.ErrInvalidClient
+	}
+	return clientID, clientSecret, nil
+}
+
+// ClientBasicOrFormHandler get client data from basic authorization when it is present, otherwise from form
+func ClientBasicOrFormHandler(r *http.Request) (string, string, error) {
+	if _, _, ok := r.BasicAuth(); ok {
+		return ClientBasicHandler(r)
+	}
+	return ClientFormHandler(r)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// token type hints of RFC 7009 and RFC 7662
+const (
+	AccessTokenHint  = "access_token"
+	RefreshTokenHint = "refresh_token"
+)
+
+// ValidationIntrospectionRequest the introspection request validation,
+// only confidential clients may introspect
+func (s *Server) ValidationIntrospectionRequest(r *http.Request) (token, hint string, err error) {
+	if r.Method != http.MethodPost {
+		return "", "", errors.ErrInvalidRequest
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", "", errors.ErrInvalidRequest
+	}
+	cli, err := s.authenticateClient(r)
+	if err != nil {
+		return "", "", err
+	} else if cli.GetSecret() == "" {
+		return "", "", errors.ErrInvalidClient
+	}
+
+	token = r.PostForm.Get("token")
+	if token == "" {
+		return "", "", errors.ErrInvalidRequest
+	}
+	return token, r.PostForm.Get("token_type_hint"), nil
+}
+
+// LoadToken the token information of an access or a refresh token,
+// the hint decides which one is tried first
+func (s *Server) LoadToken(ctx context.Context, token, hint string) (ti jwts.TokenInfo, isRefresh bool, err error) {
+	loaders := []func() (jwts.TokenInfo, error){
+		func() (jwts.TokenInfo, error) { return s.Manager.LoadAccessToken(ctx, token) },
+		func() (jwts.TokenInfo, error) { return s.Manager.LoadRefreshToken(ctx, token) },
+	}
+	first := 0
+	if hint == RefreshTokenHint {
+		first = 1
+	}
+	for i := range loaders {
+		n := (first + i) % len(loaders)
+		if ti, err = loaders[n](); err == nil {
+			return ti, n == 1, nil
+		}
+	}
+	return nil, false, err
+}
+
+// GetIntrospectionData the RFC 7662 introspection response data, inactive tokens tell nothing
+func (s *Server) GetIntrospectionData(ctx context.Context, ti jwts.TokenInfo, isRefresh bool) map[string]interface{} {
+	if ti == nil {
+		return map[string]interface{}{"active": false}
+	}
+	data := map[string]interface{}{
+		"active": true,
+	}
+	if isRefresh {
+		data["iat"] = ti.GetRefreshCreateAt().Unix()
+		if exp := ti.GetRefreshExpiresIn(); exp != 0 {
+			data["exp"] = ti.GetRefreshCreateAt().Add(exp).Unix()
+		}
+	} else {
+		data[tokenType] = s.Config.TokenType
+		data["iat"] = ti.GetAccessCreateAt().Unix()
+		if exp := ti.GetAccessExpiresIn(); exp != 0 {
+			data["exp"] = ti.GetAccessCreateAt().Add(exp).Unix()
+		}
+	}
+	if v := ti.GetUserID(); v != "" {
+		data["sub"] = v
+	}
+	if v := ti.GetScope(); v != "" {
+		data[scope] = v
+	}
+	if v := ti.GetClientID(); v != "" {
+		data["client_id"] = v
+	}
+	if v := ti.GetTenantID(); v != "" {
+		data["tenant_id"] = v
+	}
+
+	if fn := s.IntrospectionHandler; fn != nil {
+		for k, v := range fn(ctx, ti) {
+			if k == "active" {
+				continue
+			}
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// HandleIntrospectionRequest RFC 7662 introspection request handling
+func (s *Server) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error {
+	token, hint, err := s.ValidationIntrospectionRequest(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	ti, isRefresh, _ := s.LoadToken(r.Context(), token, hint)
+	return s.token(w, s.GetIntrospectionData(r.Context(), ti, isRefresh), nil)
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/quanxiang-cloud/warden/pkg/device"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+const (
+	tokenType = "token_type"
+	expiresIn = "expires_in"
+	scope     = "scope"
+)
+
+// CheckGrantType check allows grant type
+func (s *Server) CheckGrantType(gt jwts.GrantType) bool {
+	for _, agt := range s.Config.AllowedGrantTypes {
+		if agt == gt {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationTokenRequest the token request validation
+func (s *Server) ValidationTokenRequest(r *http.Request) (jwts.GrantType, *jwts.TokenGenerateRequest, error) {
+	if r.Method != http.MethodPost {
+		return "", nil, errors.ErrInvalidRequest
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", nil, errors.ErrInvalidRequest
+	}
+
+	gt := jwts.GrantType(r.PostForm.Get("grant_type"))
+	if gt.String() == "" {
+		return "", nil, errors.ErrInvalidRequest
+	} else if !s.CheckGrantType(gt) {
+		return "", nil, errors.ErrUnsupportedGrantType
+	}
+
+	cli, err := s.authenticateClient(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if !clientGrantType(cli, gt) {
+		return "", nil, errors.ErrUnauthorizedClient
+	}
+
+	tgr := &jwts.TokenGenerateRequest{
+		ClientID:  cli.GetID(),
+		GrantType: gt,
+		Scope:     r.PostForm.Get(scope),
+		UserAgent: device.UserAgent(r),
+	}
+	tgr.DeviceName = device.Name(tgr.UserAgent)
+	if fn := s.ClientIPHandler; fn != nil {
+		tgr.ClientIP = fn(r)
+	}
+	if gt != jwts.Refreshing {
+		if tgr.Scope, err = clientScope(cli, tgr.Scope); err != nil {
+			return "", nil, err
+		}
+	}
+
+	switch gt {
+	case jwts.PasswordCredentials:
+		username, password := r.PostForm.Get("username"), r.PostForm.Get("password")
+		if username == "" || password == "" {
+			return "", nil, errors.ErrInvalidRequest
+		}
+		if s.PasswordAuthorizationHandler == nil {
+			return "", nil, errors.ErrUnsupportedGrantType
+		}
+		tgr.LoginType = r.PostForm.Get("login_type")
+		userID, err := s.PasswordAuthorizationHandler(r.Context(), tgr.LoginType, username, password)
+		if err != nil {
+			return "", nil, err
+		} else if userID == "" {
+			return "", nil, errors.ErrInvalidGrant
+		}
+		tgr.UserID = userID
+	case jwts.ClientCredentials:
+		// a public client can't prove who it is
+		if cli.GetSecret() == "" {
+			return "", nil, errors.ErrUnauthorizedClient
+		}
+		tgr.UserID = cli.GetUserID()
+	case jwts.AuthorizationCode:
+		tgr.Code = r.PostForm.Get("code")
+		if tgr.Code == "" {
+			return "", nil, errors.ErrInvalidRequest
+		}
+		tgr.RedirectURI = r.PostForm.Get("redirect_uri")
+		tgr.CodeVerifier = r.PostForm.Get("code_verifier")
+	case jwts.Refreshing:
+		tgr.Refresh = r.PostForm.Get("refresh_token")
+		if tgr.Refresh == "" {
+			return "", nil, errors.ErrInvalidRequest
+		}
+	}
+	return gt, tgr, nil
+}
+
+// authenticateClient the client of the request, a public client has no secret
+func (s *Server) authenticateClient(r *http.Request) (jwts.ClientInfo, error) {
+	clientID, clientSecret, err := s.ClientInfoHandler(r)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+	cli, err := s.Manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+	if subtle.ConstantTimeCompare([]byte(cli.GetSecret()), []byte(clientSecret)) != 1 {
+		return nil, errors.ErrInvalidClient
+	}
+	return cli, nil
+}
+
+// VerifiedClientID the id of the client whose secret the request carries, empty when it
+// isn't verified. A public client has no secret, so any request can name it.
+func (s *Server) VerifiedClientID(r *http.Request) string {
+	if err := r.ParseForm(); err != nil {
+		return ""
+	}
+	cli, err := s.authenticateClient(r)
+	if err != nil || cli.GetSecret() == "" {
+		return ""
+	}
+	return cli.GetID()
+}
+
+func clientGrantType(cli jwts.ClientInfo, gt jwts.GrantType) bool {
+	grantTypes := cli.GetGrantTypes()
+	if len(grantTypes) == 0 {
+		return true
+	}
+	for _, v := range grantTypes {
+		if v == gt {
+			return true
+		}
+	}
+	return false
+}
+
+// clientScope the requested scope must be granted to the client,
+// the client scopes are granted when nothing is requested
+func clientScope(cli jwts.ClientInfo, requested string) (string, error) {
+	granted := strings.Join(cli.GetScopes(), " ")
+	if requested == "" {
+		return granted, nil
+	}
+	if granted != "" && !jwts.ContainsScope(granted, requested) {
+		return "", errors.ErrInvalidScope
+	}
+	return strings.Join(strings.Fields(requested), " "), nil
+}
+
+// GetOAuthAccessToken obtain the access token of the grant
+func (s *Server) GetOAuthAccessToken(ctx context.Context, gt jwts.GrantType, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
+	switch gt {
+	case jwts.AuthorizationCode:
+		if err := s.Manager.ConsumeAuthorizationCode(ctx, tgr); err != nil {
+			return nil, grantError(err)
+		}
+		fallthrough
+	case jwts.PasswordCredentials, jwts.ClientCredentials:
+		if fn := s.ClaimsHandler; fn != nil && tgr.UserID != "" {
+			if err := fn(ctx, tgr); err != nil {
+				return nil, err
+			}
+		}
+		return s.Manager.GenerateAccessToken(ctx, tgr)
+	case jwts.Refreshing:
+		ti, err := s.Manager.RefreshAccessToken(ctx, tgr)
+		if err != nil {
+			return nil, grantError(err)
+		}
+		return ti, nil
+	}
+	return nil, errors.ErrUnsupportedGrantType
+}
+
+// grantError the invalid code or refresh token is an invalid grant
+func grantError(err error) error {
+	switch err {
+	case errors.ErrInvalidRefreshToken, errors.ErrExpiredRefreshToken, errors.ErrReusedRefreshToken,
+		errors.ErrInvalidAuthorizeCode, errors.ErrInvalidCodeChallenge, errors.ErrMissingCodeVerifier:
+		return errors.ErrInvalidGrant
+	}
+	return err
+}
+
+// GetOAuthTokenData the RFC 6749 token response data
+func (s *Server) GetOAuthTokenData(ti jwts.TokenInfo) map[string]interface{} {
+	data := map[string]interface{}{
+		acsToken:  ti.GetAccess(),
+		tokenType: s.Config.TokenType,
+		expiresIn: int64(ti.GetAccessExpiresIn() / 1e9),
+	}
+
+	if v := ti.GetScope(); v != "" {
+		data[scope] = v
+	}
+
+	if refresh := ti.GetRefresh(); refresh != "" {
+		data[refreshToken] = refresh
+	}
+
+	if fn := s.ExtensionFieldsHandler; fn != nil {
+		ext := fn(ti)
+		for k, v := range ext {
+			if _, ok := data[k]; ok {
+				continue
+			}
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// GetErrorData get error response data,
+// errors which aren't defined by RFC 6749 are server errors
+func (s *Server) GetErrorData(err error) (map[string]interface{}, int, http.Header) {
+	re := errors.NewResponse(errors.ErrServerError, errors.StatusCodes[errors.ErrServerError])
+	if v, ok := errors.Descriptions[err]; ok {
+		re.Error = err
+		re.Description = v
+		re.StatusCode = errors.StatusCodes[err]
+	} else {
+		re.Description = errors.Descriptions[errors.ErrServerError]
+		if fn := s.InternalErrorHandler; fn != nil {
+			if v := fn(err); v != nil {
+				re = v
+			}
+		}
+	}
+	if re.Error == errors.ErrInvalidClient {
+		re.SetHeader("WWW-Authenticate", `Basic realm="warden"`)
+	}
+
+	data := map[string]interface{}{
+		"error":             re.Error.Error(),
+		"error_description": re.Description,
+	}
+	return data, re.StatusCode, re.Header
+}
+
+// HandleOAuthTokenRequest RFC 6749 token request handling
+func (s *Server) HandleOAuthTokenRequest(w http.ResponseWriter, r *http.Request) error {
+	gt, tgr, err := s.ValidationTokenRequest(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	ti, err := s.GetOAuthAccessToken(r.Context(), gt, tgr)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	return s.token(w, s.GetOAuthTokenData(ti), nil)
+}
+
+func (s *Server) tokenError(w http.ResponseWriter, err error) error {
+	data, statusCode, header := s.GetErrorData(err)
+	return s.token(w, data, header, statusCode)
+}
+
+func (s *Server) token(w http.ResponseWriter, data map[string]interface{}, header http.Header, statusCode ...int) error {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	for key := range header {
+		w.Header().Set(key, header.Get(key))
+	}
+
+	status := http.StatusOK
+	if len(statusCode) > 0 && statusCode[0] > 0 {
+		status = statusCode[0]
+	}
+
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(data)
+}
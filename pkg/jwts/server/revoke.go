@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+// ValidationRevocationRequest the revocation request validation,
+// the client authenticates as it does at the token endpoint
+func (s *Server) ValidationRevocationRequest(r *http.Request) (token string, cli jwts.ClientInfo, err error) {
+	if r.Method != http.MethodPost {
+		return "", nil, errors.ErrInvalidRequest
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", nil, errors.ErrInvalidRequest
+	}
+	if cli, err = s.authenticateClient(r); err != nil {
+		return "", nil, err
+	}
+
+	token = r.PostForm.Get("token")
+	if token == "" {
+		return "", nil, errors.ErrInvalidRequest
+	}
+	// token_type_hint is only a hint, the session is found by either token
+	return token, cli, nil
+}
+
+// HandleRevocationRequest RFC 7009 revocation request handling,
+// an invalid token is answered with 200 as well
+func (s *Server) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) error {
+	token, cli, err := s.ValidationRevocationRequest(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+	if err = s.Manager.RevokeToken(r.Context(), token, cli.GetID()); err != nil {
+		return s.tokenError(w, err)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"github.com/quanxiang-cloud/warden/pkg/device"
 	"github.com/quanxiang-cloud/warden/pkg/jwts"
 	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
 	"strings"
@@ -27,6 +28,10 @@ func NewServer(cfg *Config, manager jwts.Manager) *Server {
 		Config:  cfg,
 		Manager: manager,
 	}
+
+	// default handler
+	srv.ClientInfoHandler = ClientBasicOrFormHandler
+	srv.ClientIPHandler = device.Proxies(nil).ClientIP
 	return srv
 }
 
@@ -35,9 +40,16 @@ type Server struct {
 	Config  *Config
 	Manager jwts.Manager
 
-	RefreshingValidationHandler RefreshingValidationHandler
-	ExtensionFieldsHandler      ExtensionFieldsHandler
-	AccessTokenExpHandler       AccessTokenExpHandler
+	ClientInfoHandler            ClientInfoHandler
+	ClientIPHandler              ClientIPHandler
+	PasswordAuthorizationHandler PasswordAuthorizationHandler
+	ClaimsHandler                ClaimsHandler
+	UserAuthorizationHandler     UserAuthorizationHandler
+	InternalErrorHandler         InternalErrorHandler
+	IntrospectionHandler         IntrospectionHandler
+	RefreshingValidationHandler  RefreshingValidationHandler
+	ExtensionFieldsHandler       ExtensionFieldsHandler
+	AccessTokenExpHandler        AccessTokenExpHandler
 }
 
 // GetAuthorizeData get authorization response data
@@ -46,9 +58,9 @@ func (s *Server) GetAuthorizeData(ti jwts.TokenInfo) map[string]interface{} {
 }
 
 // GetAccessToken access token
-func (s *Server) GetAccessToken(ctx context.Context, jti string, otherInfo map[string]string) (jwts.TokenInfo, error) {
+func (s *Server) GetAccessToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
 
-	ti, err := s.Manager.GenerateAccessToken(ctx, jti, otherInfo)
+	ti, err := s.Manager.GenerateAccessToken(ctx, tgr)
 	if err != nil {
 		switch err {
 		default:
@@ -60,9 +72,9 @@ func (s *Server) GetAccessToken(ctx context.Context, jti string, otherInfo map[s
 }
 
 // GetRefreshAccessToken access token
-func (s *Server) GetRefreshAccessToken(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+func (s *Server) GetRefreshAccessToken(ctx context.Context, tgr *jwts.TokenGenerateRequest) (jwts.TokenInfo, error) {
 
-	ti, err := s.Manager.RefreshAccessToken(ctx, refresh)
+	ti, err := s.Manager.RefreshAccessToken(ctx, tgr)
 	if err != nil {
 		switch err {
 		default:
@@ -98,7 +110,15 @@ func (s *Server) GetTokenData(ti jwts.TokenInfo) map[string]interface{} {
 
 // HandleTokenRequest token request handling
 func (s *Server) HandleTokenRequest(c context.Context, jti string, otherInfo map[string]string) (token map[string]interface{}, err error) {
-	ti, err := s.GetAccessToken(c, jti, otherInfo)
+	return s.HandleTokenGenerateRequest(c, &jwts.TokenGenerateRequest{
+		UserID:    jti,
+		OtherInfo: otherInfo,
+	})
+}
+
+// HandleTokenGenerateRequest token request handling
+func (s *Server) HandleTokenGenerateRequest(c context.Context, tgr *jwts.TokenGenerateRequest) (token map[string]interface{}, err error) {
+	ti, err := s.GetAccessToken(c, tgr)
 	if err != nil {
 		return nil, err
 	}
@@ -108,8 +128,14 @@ func (s *Server) HandleTokenRequest(c context.Context, jti string, otherInfo map
 
 // HandleRefreshTokenRequest newToken request handling
 func (s *Server) HandleRefreshTokenRequest(c context.Context, refresh string) (token map[string]interface{}, err error) {
+	return s.HandleRefreshTokenGenerateRequest(c, &jwts.TokenGenerateRequest{
+		Refresh: refresh,
+	})
+}
 
-	ti, err := s.GetRefreshAccessToken(c, refresh)
+// HandleRefreshTokenGenerateRequest newToken request handling
+func (s *Server) HandleRefreshTokenGenerateRequest(c context.Context, tgr *jwts.TokenGenerateRequest) (token map[string]interface{}, err error) {
+	ti, err := s.GetRefreshAccessToken(c, tgr)
 	if err != nil {
 		return nil, err
 	}
@@ -2,19 +2,44 @@ package jwts
 
 import (
 	"context"
+	"time"
 )
 
 // TokenStore TokenStore
 type TokenStore interface {
 	Create(ctx context.Context, info TokenInfo) error
 
+	RemoveByCode(ctx context.Context, code string) error
+
 	RemoveByAccess(ctx context.Context, access string) error
 
 	RemoveByRefresh(ctx context.Context, refresh string) error
 
+	GetByCode(ctx context.Context, code string) (TokenInfo, error)
+
+	// TakeByCode get and remove the authorization code at once,
+	// nil if it isn't known or another caller has taken it
+	TakeByCode(ctx context.Context, code string) (TokenInfo, error)
+
 	GetByAccess(ctx context.Context, access string) (TokenInfo, error)
 
 	GetByRefresh(ctx context.Context, refresh string) (TokenInfo, error)
 
 	RemoveToken(ctx context.Context, jti string) error
+
+	// GetByUserID the token information of every session of the user
+	GetByUserID(ctx context.Context, userID string) ([]TokenInfo, error)
+
+	// RemoveByToken delete the whole session of an access or a refresh token
+	RemoveByToken(ctx context.Context, token string) error
+
+	// MarkRotated remember a refresh token which has been rotated, until exp.
+	// It is false when the refresh token has already been marked, only one caller claims it
+	MarkRotated(ctx context.Context, refresh string, info TokenInfo, exp time.Duration) (bool, error)
+
+	// GetRotated the token information of a rotated refresh token, nil if it isn't known
+	GetRotated(ctx context.Context, refresh string) (TokenInfo, error)
+
+	// RemoveFamily delete every token of the family
+	RemoveFamily(ctx context.Context, userID, familyID string) error
 }
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/errors"
+)
+
+var (
+	_ jwts.ClientStore = &ClientStore{}
+)
+
+// NewClientStore create client store
+func NewClientStore() *ClientStore {
+	return &ClientStore{
+		data: make(map[string]jwts.ClientInfo),
+	}
+}
+
+// ClientStore client information store
+type ClientStore struct {
+	sync.RWMutex
+	data map[string]jwts.ClientInfo
+}
+
+// GetByID according to the ID for the client information
+func (cs *ClientStore) GetByID(ctx context.Context, id string) (jwts.ClientInfo, error) {
+	cs.RLock()
+	defer cs.RUnlock()
+
+	if c, ok := cs.data[id]; ok {
+		return c, nil
+	}
+	return nil, errors.ErrInvalidClient
+}
+
+// Set set client information
+func (cs *ClientStore) Set(id string, cli jwts.ClientInfo) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.data[id] = cli
+	return nil
+}
@@ -0,0 +1,395 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/models"
+)
+
+var (
+	_ jwts.TokenStore = &MemoryTokenStore{}
+)
+
+// DefaultSweepInterval default interval of the memory store sweeper
+const DefaultSweepInterval = time.Minute
+
+// memoryEntry value with an optional expiration, zero expireAt means it doesn't expire
+type memoryEntry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !e.expireAt.After(now)
+}
+
+// NewMemoryTokenStore create an instance of a memory store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return NewMemoryTokenStoreWithInterval(DefaultSweepInterval)
+}
+
+// NewMemoryTokenStoreWithInterval create an instance of a memory store,
+// expired entries are swept every interval
+func NewMemoryTokenStoreWithInterval(interval time.Duration) *MemoryTokenStore {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	store := &MemoryTokenStore{
+		entries: make(map[string]*memoryEntry),
+		users:   make(map[string]map[string]string),
+		done:    make(chan struct{}),
+	}
+	go store.sweep(interval)
+	return store
+}
+
+// MemoryTokenStore memory token store, it keeps the same layout as the redis store:
+// access and refresh tokens point to a basicID which holds the token information,
+// and every user has an index of basicID -> access token
+type MemoryTokenStore struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+	users   map[string]map[string]string
+
+	once sync.Once
+	done chan struct{}
+}
+
+// Close stop the sweeper
+func (s *MemoryTokenStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+func (s *MemoryTokenStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.purge(time.Now())
+		}
+	}
+}
+
+// purge remove expired entries and the user index pointing to them
+func (s *MemoryTokenStore) purge(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.entries {
+		if v.expired(now) {
+			delete(s.entries, k)
+		}
+	}
+	for userID := range s.users {
+		s.cleanByUser(userID)
+	}
+}
+
+func (s *MemoryTokenStore) set(key string, value []byte, exp time.Duration, now time.Time) {
+	e := &memoryEntry{value: value}
+	if exp > 0 {
+		e.expireAt = now.Add(exp)
+	}
+	s.entries[JWTRedis+key] = e
+}
+
+func (s *MemoryTokenStore) get(key string) []byte {
+	e, ok := s.entries[JWTRedis+key]
+	if !ok || e.expired(time.Now()) {
+		return nil
+	}
+	return e.value
+}
+
+func (s *MemoryTokenStore) exists(key string) bool {
+	return s.get(key) != nil
+}
+
+func (s *MemoryTokenStore) remove(key string) {
+	delete(s.entries, JWTRedis+key)
+}
+
+// cleanByUser drop the basicIDs of the user whose token information is gone
+func (s *MemoryTokenStore) cleanByUser(userID string) {
+	basicIDs, ok := s.users[userID]
+	if !ok {
+		return
+	}
+	for basicID := range basicIDs {
+		if !s.exists(basicID) {
+			delete(basicIDs, basicID)
+		}
+	}
+	if len(basicIDs) == 0 {
+		delete(s.users, userID)
+	}
+}
+
+func (s *MemoryTokenStore) getToken(basicID string) (jwts.TokenInfo, error) {
+	buf := s.get(basicID)
+	if buf == nil {
+		return nil, nil
+	}
+	var token models.Token
+	if err := jsonUnmarshal(buf, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) getByToken(tokenString string) (jwts.TokenInfo, error) {
+	basicID := s.get(tokenString)
+	if basicID == nil {
+		return nil, nil
+	}
+	return s.getToken(string(basicID))
+}
+
+func (s *MemoryTokenStore) removeToken(tokenString string, isRefresh bool) error {
+	basicID := s.get(tokenString)
+	if basicID == nil {
+		return nil
+	}
+	s.remove(tokenString)
+
+	token, err := s.getToken(string(basicID))
+	if err != nil {
+		return err
+	} else if token == nil {
+		return nil
+	}
+
+	checkToken := token.GetRefresh()
+	if isRefresh {
+		checkToken = token.GetAccess()
+	}
+	if checkToken == "" || !s.exists(checkToken) {
+		s.remove(string(basicID))
+		if basicIDs, ok := s.users[token.GetUserID()]; ok {
+			delete(basicIDs, string(basicID))
+		}
+	}
+	return nil
+}
+
+// Create Create and store the new token information
+func (s *MemoryTokenStore) Create(ctx context.Context, info jwts.TokenInfo) error {
+	ct := time.Now()
+	jv, err := jsonMarshal(info)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the authorization code is stored alone until it is exchanged
+	if code := info.GetCode(); code != "" {
+		s.set(code, jv, info.GetCodeExpiresIn(), ct)
+		return nil
+	}
+
+	userID := info.GetUserID()
+	basicID := uuid.Must(uuid.NewRandom()).String()
+	aexp := info.GetAccessExpiresIn()
+	rexp := aexp
+
+	if refresh := info.GetRefresh(); refresh != "" {
+		rexp = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()).Sub(ct)
+		if aexp.Seconds() > rexp.Seconds() {
+			aexp = rexp
+		}
+		s.set(refresh, []byte(basicID), rexp, ct)
+	}
+
+	s.set(info.GetAccess(), []byte(basicID), aexp, ct)
+	s.set(basicID, jv, rexp, ct)
+
+	// tokens of a client without user aren't indexed
+	if userID == "" {
+		return nil
+	}
+	basicIDs, ok := s.users[userID]
+	if !ok {
+		basicIDs = make(map[string]string)
+		s.users[userID] = basicIDs
+	}
+	basicIDs[basicID] = info.GetAccess()
+	return nil
+}
+
+// RemoveByCode Use the authorization code to delete the token information
+func (s *MemoryTokenStore) RemoveByCode(ctx context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(code)
+	return nil
+}
+
+// RemoveByAccess Use the access token to delete the token information
+func (s *MemoryTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeToken(access, false)
+}
+
+// RemoveByRefresh Use the refresh token to delete the token information
+func (s *MemoryTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokenInfo, _ := s.getByToken(refresh)
+	err := s.removeToken(refresh, true)
+	if tokenInfo != nil {
+		s.cleanByUser(tokenInfo.GetUserID())
+	}
+	return err
+}
+
+// GetByCode Use the authorization code for token information data
+func (s *MemoryTokenStore) GetByCode(ctx context.Context, code string) (jwts.TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getToken(code)
+}
+
+// TakeByCode get and remove the authorization code under the lock
+func (s *MemoryTokenStore) TakeByCode(ctx context.Context, code string) (jwts.TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ti, err := s.getToken(code)
+	if err != nil || ti == nil {
+		return nil, err
+	}
+	s.remove(code)
+	return ti, nil
+}
+
+// GetByAccess Use the access token for token information data
+func (s *MemoryTokenStore) GetByAccess(ctx context.Context, access string) (jwts.TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getByToken(access)
+}
+
+// GetByRefresh Use the refresh token for token information data
+func (s *MemoryTokenStore) GetByRefresh(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getByToken(refresh)
+}
+
+// GetByUserID the token information of every session of the user
+func (s *MemoryTokenStore) GetByUserID(ctx context.Context, userID string) ([]jwts.TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]jwts.TokenInfo, 0, len(s.users[userID]))
+	for basicID := range s.users[userID] {
+		tokenInfo, err := s.getToken(basicID)
+		if err != nil {
+			return nil, err
+		} else if tokenInfo != nil {
+			infos = append(infos, tokenInfo)
+		}
+	}
+	return infos, nil
+}
+
+// RemoveToken Use the jti to delete the token information data
+func (s *MemoryTokenStore) RemoveToken(ctx context.Context, jti string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for basicID := range s.users[jti] {
+		tokenInfo, _ := s.getToken(basicID)
+		if tokenInfo != nil {
+			s.remove(tokenInfo.GetAccess())
+			s.remove(tokenInfo.GetRefresh())
+		}
+		s.remove(basicID)
+	}
+	delete(s.users, jti)
+	return nil
+}
+
+// RemoveByToken Use the access or the refresh token to delete the whole session through its basicID
+func (s *MemoryTokenStore) RemoveByToken(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	basicID := s.get(token)
+	if basicID == nil {
+		return nil
+	}
+	tokenInfo, err := s.getToken(string(basicID))
+	if err != nil {
+		return err
+	}
+	s.remove(token)
+	s.remove(string(basicID))
+	if tokenInfo != nil {
+		s.remove(tokenInfo.GetAccess())
+		s.remove(tokenInfo.GetRefresh())
+		if basicIDs, ok := s.users[tokenInfo.GetUserID()]; ok {
+			delete(basicIDs, string(basicID))
+		}
+	}
+	return nil
+}
+
+// MarkRotated remember a refresh token which has been rotated, until exp, false if it has been marked
+func (s *MemoryTokenStore) MarkRotated(ctx context.Context, refresh string, info jwts.TokenInfo, exp time.Duration) (bool, error) {
+	jv, err := jsonMarshal(info)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exists(rotatedKey(refresh)) {
+		return false, nil
+	}
+	s.set(rotatedKey(refresh), jv, exp, time.Now())
+	return true, nil
+}
+
+// GetRotated the token information of a rotated refresh token
+func (s *MemoryTokenStore) GetRotated(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getToken(rotatedKey(refresh))
+}
+
+// RemoveFamily delete every token of the family
+func (s *MemoryTokenStore) RemoveFamily(ctx context.Context, userID, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	basicIDs := s.users[userID]
+	for basicID := range basicIDs {
+		tokenInfo, err := s.getToken(basicID)
+		if err != nil {
+			return err
+		}
+		if tokenInfo != nil && tokenInfo.GetFamilyID() != familyID {
+			continue
+		}
+		if tokenInfo != nil {
+			s.remove(tokenInfo.GetAccess())
+			s.remove(tokenInfo.GetRefresh())
+		}
+		s.remove(basicID)
+		delete(basicIDs, basicID)
+	}
+	return nil
+}
+
+// rotatedKey the key of a rotated refresh token, the same as the redis store
+func rotatedKey(refresh string) string {
+	return JWTRedisRotated[len(JWTRedis):] + refresh
+}
@@ -23,6 +23,8 @@ const (
 	JWTRedis = "jwt:"
 	// JWTRedisUsers 当前服务用户相关
 	JWTRedisUsers = "jwt:users:"
+	// JWTRedisRotated 已轮换的 refresh token
+	JWTRedisRotated = "jwt:rotated:"
 )
 
 // NewRedisStore create an instance of a redis store
@@ -67,6 +69,8 @@ func NewRedisClusterStoreWithCli(cli *redis.ClusterClient, keyNamespace ...strin
 
 type clienter interface {
 	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
 	Exists(ctx context.Context, key ...string) *redis.IntCmd
 	TxPipeline() redis.Pipeliner
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
@@ -139,7 +143,7 @@ func (s *RedisTokenStore) removeToken(ctx context.Context, tokenString string, i
 	if isRefresh {
 		checkToken = token.GetAccess()
 	}
-	iresult := s.cli.Exists(ctx, s.wrapperKey(checkToken))
+	iresult := s.cli.Exists(ctx, s.wrapperKey(JWTRedis+checkToken))
 	if err := iresult.Err(); err != nil && err != redis.Nil {
 		return err
 	} else if iresult.Val() == 0 {
@@ -191,13 +195,12 @@ func (s *RedisTokenStore) getBasicID(ctx context.Context, token string) (string,
 }
 
 func (s *RedisTokenStore) cleanByUser(ctx context.Context, userID string) {
-	//遍历用户下的keys，如果某个key的value的value没有了，那就是无效key，就清除
-	basicIDs := s.cli.HKeys(ctx, JWTRedisUsers+userID).Val()
+	//遍历用户下的keys，如果某个key对应的token信息没有了，那就是无效key，就清除
+	//access token 过期但 refresh token 仍有效的会话需要保留，否则按用户或 family 删除时会遗漏
+	basicIDs := s.cli.HKeys(ctx, s.wrapperKey(JWTRedisUsers+userID)).Val()
 	for _, v := range basicIDs {
-		otherAccess := s.cli.HGet(ctx, JWTRedisUsers+userID, v).Val()
-		b := s.cli.Get(ctx, JWTRedis+otherAccess).Val()
-		if b == "" {
-			s.cli.HDel(ctx, JWTRedisUsers+userID, v)
+		if s.cli.Exists(ctx, s.wrapperKey(JWTRedis+v)).Val() == 0 {
+			s.cli.HDel(ctx, s.wrapperKey(JWTRedisUsers+userID), v)
 		}
 	}
 }
@@ -209,6 +212,13 @@ func (s *RedisTokenStore) Create(ctx context.Context, info jwts.TokenInfo) error
 	if err != nil {
 		return err
 	}
+	// the authorization code is stored alone until it is exchanged
+	if code := info.GetCode(); code != "" {
+		result := s.cli.Set(ctx, s.wrapperKey(JWTRedis+code), jv, info.GetCodeExpiresIn())
+		_, err = s.checkError(result)
+		return err
+	}
+
 	var userID = ""
 	pipe := s.cli.TxPipeline()
 
@@ -228,8 +238,11 @@ func (s *RedisTokenStore) Create(ctx context.Context, info jwts.TokenInfo) error
 	pipe.Set(ctx, s.wrapperKey(JWTRedis+info.GetAccess()), basicID, aexp)
 	pipe.Set(ctx, s.wrapperKey(JWTRedis+basicID), jv, rexp)
 
-	pipe.HSet(ctx, s.wrapperKey(JWTRedisUsers+userID), basicID, info.GetAccess())
-	pipe.Expire(ctx, s.wrapperKey(JWTRedisUsers+userID), info.GetRefreshExpiresIn())
+	// tokens of a client without user aren't indexed
+	if userID != "" {
+		pipe.HSet(ctx, s.wrapperKey(JWTRedisUsers+userID), basicID, info.GetAccess())
+		pipe.Expire(ctx, s.wrapperKey(JWTRedisUsers+userID), info.GetRefreshExpiresIn())
+	}
 	if _, err := pipe.Exec(ctx); err != nil {
 		fmt.Println(err)
 		return err
@@ -267,6 +280,20 @@ func (s *RedisTokenStore) GetByCode(ctx context.Context, code string) (jwts.Toke
 	return s.getToken(ctx, code)
 }
 
+// TakeByCode get and remove the authorization code in one transaction, only the caller which deleted it gets it
+func (s *RedisTokenStore) TakeByCode(ctx context.Context, code string) (jwts.TokenInfo, error) {
+	pipe := s.cli.TxPipeline()
+	get := pipe.Get(ctx, s.wrapperKey(JWTRedis+code))
+	del := pipe.Del(ctx, s.wrapperKey(JWTRedis+code))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if del.Val() != 1 {
+		return nil, nil
+	}
+	return s.parseToken(get)
+}
+
 // GetByAccess Use the access token for token information data
 func (s *RedisTokenStore) GetByAccess(ctx context.Context, access string) (jwts.TokenInfo, error) {
 	basicID, err := s.getBasicID(ctx, access)
@@ -285,13 +312,33 @@ func (s *RedisTokenStore) GetByRefresh(ctx context.Context, refresh string) (jwt
 	return s.getToken(ctx, basicID)
 }
 
+// GetByUserID the token information of every session of the user, stale keys of the user index are dropped
+func (s *RedisTokenStore) GetByUserID(ctx context.Context, userID string) ([]jwts.TokenInfo, error) {
+	basicIDs, err := s.cli.HKeys(ctx, s.wrapperKey(JWTRedisUsers+userID)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	infos := make([]jwts.TokenInfo, 0, len(basicIDs))
+	for _, basicID := range basicIDs {
+		tokenInfo, err := s.getToken(ctx, basicID)
+		if err != nil {
+			return nil, err
+		} else if tokenInfo == nil {
+			_ = s.hRemove(ctx, userID, basicID)
+			continue
+		}
+		infos = append(infos, tokenInfo)
+	}
+	return infos, nil
+}
+
 // RemoveToken Use the jti to delete the token information data
 func (s *RedisTokenStore) RemoveToken(ctx context.Context, jti string) error {
-	keys := s.cli.HKeys(ctx, JWTRedisUsers+jti).Val()
+	keys := s.cli.HKeys(ctx, s.wrapperKey(JWTRedisUsers+jti)).Val()
 	for _, v := range keys {
-		access := s.cli.HGet(ctx, JWTRedisUsers+jti, v).Val()
-		tokenInfo, _ := s.GetByAccess(ctx, access)
+		tokenInfo, _ := s.getToken(ctx, v)
 		if tokenInfo == nil {
+			s.cli.HDel(ctx, s.wrapperKey(JWTRedisUsers+jti), v)
 			continue
 		}
 		_ = s.RemoveByAccess(ctx, tokenInfo.GetAccess())
@@ -300,8 +347,77 @@ func (s *RedisTokenStore) RemoveToken(ctx context.Context, jti string) error {
 			s.cleanByUser(ctx, userID)
 		}
 		_ = s.RemoveByRefresh(ctx, tokenInfo.GetRefresh())
-		s.cli.Del(ctx, JWTRedis+v)
-		s.cli.HDel(ctx, JWTRedisUsers+jti, v)
+		s.cli.Del(ctx, s.wrapperKey(JWTRedis+v))
+		s.cli.HDel(ctx, s.wrapperKey(JWTRedisUsers+jti), v)
+	}
+	return nil
+}
+
+// RemoveByToken Use the access or the refresh token to delete the whole session through its basicID,
+// the keys are deleted one by one as they are apart in a cluster
+func (s *RedisTokenStore) RemoveByToken(ctx context.Context, token string) error {
+	basicID, err := s.getBasicID(ctx, token)
+	if err != nil || basicID == "" {
+		return err
+	}
+	tokenInfo, err := s.getToken(ctx, basicID)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{token, basicID}
+	if tokenInfo != nil {
+		keys = append(keys, tokenInfo.GetAccess())
+		if refresh := tokenInfo.GetRefresh(); refresh != "" {
+			keys = append(keys, refresh)
+		}
+	}
+	for _, key := range keys {
+		if err = s.remove(ctx, key); err != nil {
+			return err
+		}
+	}
+	if tokenInfo != nil && tokenInfo.GetUserID() != "" {
+		return s.hRemove(ctx, tokenInfo.GetUserID(), basicID)
+	}
+	return nil
+}
+
+// MarkRotated remember a refresh token which has been rotated, until exp, false if it has been marked
+func (s *RedisTokenStore) MarkRotated(ctx context.Context, refresh string, info jwts.TokenInfo, exp time.Duration) (bool, error) {
+	jv, err := jsonMarshal(info)
+	if err != nil {
+		return false, err
+	}
+	return s.cli.SetNX(ctx, s.wrapperKey(JWTRedisRotated+refresh), jv, exp).Result()
+}
+
+// GetRotated the token information of a rotated refresh token
+func (s *RedisTokenStore) GetRotated(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+	result := s.cli.Get(ctx, s.wrapperKey(JWTRedisRotated+refresh))
+	return s.parseToken(result)
+}
+
+// RemoveFamily delete every token of the family
+func (s *RedisTokenStore) RemoveFamily(ctx context.Context, userID, familyID string) error {
+	basicIDs, err := s.cli.HKeys(ctx, s.wrapperKey(JWTRedisUsers+userID)).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	for _, basicID := range basicIDs {
+		tokenInfo, err := s.getToken(ctx, basicID)
+		if err != nil {
+			return err
+		}
+		if tokenInfo != nil && tokenInfo.GetFamilyID() != familyID {
+			continue
+		}
+		if tokenInfo != nil {
+			_ = s.remove(ctx, tokenInfo.GetAccess())
+			_ = s.remove(ctx, tokenInfo.GetRefresh())
+		}
+		_ = s.remove(ctx, basicID)
+		_ = s.hRemove(ctx, userID, basicID)
 	}
 	return nil
 }
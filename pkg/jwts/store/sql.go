@@ -0,0 +1,439 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/models"
+)
+
+var (
+	_ jwts.TokenStore = &SQLTokenStore{}
+)
+
+const (
+	// DefaultPurgeInterval default interval of the sql store purge
+	DefaultPurgeInterval = 10 * time.Minute
+
+	sqlTokenTable     = "jwt_tokens"
+	sqlRotatedTable   = "jwt_rotated_tokens"
+	sqlCodeTable      = "jwt_codes"
+	sqlMigrationTable = "jwt_schema_migrations"
+)
+
+// sqlMigrations schema migrations, append only, the index+1 is the version
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS ` + sqlTokenTable + ` (
+		basic_id VARCHAR(64) NOT NULL PRIMARY KEY,
+		user_id VARCHAR(64) NOT NULL,
+		access TEXT,
+		access_expires_at BIGINT NOT NULL DEFAULT 0,
+		refresh TEXT,
+		expires_at BIGINT NOT NULL DEFAULT 0,
+		data TEXT NOT NULL,
+		created_at BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + sqlTokenTable + `_access ON ` + sqlTokenTable + ` (access)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + sqlTokenTable + `_refresh ON ` + sqlTokenTable + ` (refresh)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + sqlTokenTable + `_user_id ON ` + sqlTokenTable + ` (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + sqlTokenTable + `_expires_at ON ` + sqlTokenTable + ` (expires_at)`,
+	`ALTER TABLE ` + sqlTokenTable + ` ADD COLUMN family_id VARCHAR(64)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + sqlTokenTable + `_family_id ON ` + sqlTokenTable + ` (user_id, family_id)`,
+	`CREATE TABLE IF NOT EXISTS ` + sqlRotatedTable + ` (
+		refresh VARCHAR(255) NOT NULL PRIMARY KEY,
+		data TEXT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS ` + sqlCodeTable + ` (
+		code VARCHAR(255) NOT NULL PRIMARY KEY,
+		data TEXT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0
+	)`,
+}
+
+// NewSQLTokenStore create an instance of a sql store, driver is the name the
+// db was opened with, it decides the placeholder style.
+// The schema is migrated before the store is returned.
+func NewSQLTokenStore(db *sql.DB, driver string, purgeInterval time.Duration) (*SQLTokenStore, error) {
+	if db == nil {
+		panic("db cannot be nil")
+	}
+	if purgeInterval <= 0 {
+		purgeInterval = DefaultPurgeInterval
+	}
+	store := &SQLTokenStore{
+		db:           db,
+		dollarParams: isDollarDriver(driver),
+		done:         make(chan struct{}),
+	}
+	if err := store.migrate(context.Background()); err != nil {
+		return nil, err
+	}
+	go store.sweep(purgeInterval)
+	return store, nil
+}
+
+// OpenSQLTokenStore open the database and create an instance of a sql store
+func OpenSQLTokenStore(driver, dsn string, purgeInterval time.Duration) (*SQLTokenStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	store, err := NewSQLTokenStore(db, driver, purgeInterval)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func isDollarDriver(driver string) bool {
+	switch driver {
+	case "postgres", "pgx", "cloudsqlpostgres":
+		return true
+	}
+	return false
+}
+
+// SQLTokenStore database/sql token store, every row is one basicID,
+// the access and refresh columns are cleared when the token is removed
+type SQLTokenStore struct {
+	db           *sql.DB
+	dollarParams bool
+
+	once sync.Once
+	done chan struct{}
+}
+
+// Close stop the purge and close the database
+func (s *SQLTokenStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return s.db.Close()
+}
+
+// rebind convert ? placeholders to $n for the drivers which need it
+func (s *SQLTokenStore) rebind(query string) string {
+	if !s.dollarParams {
+		return query
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLTokenStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.rebind(query), args...)
+}
+
+// migrate run the migrations one version per transaction. The version row is inserted
+// before the migration runs, so an instance starting at the same time waits on it or
+// fails on the key, and goes on from the version the other one has applied.
+func (s *SQLTokenStore) migrate(ctx context.Context) error {
+	_, err := s.exec(ctx, `CREATE TABLE IF NOT EXISTS `+sqlMigrationTable+` (version INTEGER NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	for {
+		version, err := s.migrationVersion(ctx)
+		if err != nil {
+			return err
+		} else if version >= len(sqlMigrations) {
+			return nil
+		}
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO `+sqlMigrationTable+` (version) VALUES (?)`), version+1); err != nil {
+			tx.Rollback()
+			// another instance has applied the version
+			if v, verr := s.migrationVersion(ctx); verr == nil && v > version {
+				continue
+			}
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, sqlMigrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *SQLTokenStore) migrationVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM `+sqlMigrationTable).Scan(&version)
+	return version, err
+}
+
+func (s *SQLTokenStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			_ = s.purge(context.Background(), time.Now())
+		}
+	}
+}
+
+// purge remove expired rows and rows without any token
+func (s *SQLTokenStore) purge(ctx context.Context, now time.Time) error {
+	_, err := s.exec(ctx, `DELETE FROM `+sqlTokenTable+
+		` WHERE (expires_at <> 0 AND expires_at <= ?) OR (access IS NULL AND refresh IS NULL)`, now.UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, `DELETE FROM `+sqlRotatedTable+` WHERE expires_at <> 0 AND expires_at <= ?`, now.UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, `DELETE FROM `+sqlCodeTable+` WHERE expires_at <> 0 AND expires_at <= ?`, now.UnixNano())
+	return err
+}
+
+// expireAt zero means it doesn't expire
+func expireAt(now time.Time, exp time.Duration) int64 {
+	if exp <= 0 {
+		return 0
+	}
+	return now.Add(exp).UnixNano()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *SQLTokenStore) parseToken(row *sql.Row) (jwts.TokenInfo, error) {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var token models.Token
+	if err := jsonUnmarshal([]byte(data), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *SQLTokenStore) removeToken(ctx context.Context, tokenString string, isRefresh bool) error {
+	// drop the whole row when the other token is gone, otherwise only clear the column
+	column, gone, args := "access", "refresh IS NULL", []interface{}{tokenString}
+	if isRefresh {
+		column, gone = "refresh", "access IS NULL OR (access_expires_at <> 0 AND access_expires_at <= ?)"
+		args = append(args, time.Now().UnixNano())
+	}
+
+	_, err := s.exec(ctx, `DELETE FROM `+sqlTokenTable+` WHERE `+column+` = ? AND (`+gone+`)`, args...)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, `UPDATE `+sqlTokenTable+` SET `+column+` = NULL WHERE `+column+` = ?`, tokenString)
+	return err
+}
+
+// Create Create and store the new token information
+func (s *SQLTokenStore) Create(ctx context.Context, info jwts.TokenInfo) error {
+	ct := time.Now()
+	jv, err := jsonMarshal(info)
+	if err != nil {
+		return err
+	}
+
+	// the authorization code is stored alone until it is exchanged
+	if code := info.GetCode(); code != "" {
+		_, err = s.exec(ctx, `INSERT INTO `+sqlCodeTable+` (code, data, expires_at) VALUES (?, ?, ?)`,
+			code, string(jv), expireAt(ct, info.GetCodeExpiresIn()))
+		return err
+	}
+
+	basicID := uuid.Must(uuid.NewRandom()).String()
+	aexp := info.GetAccessExpiresIn()
+	rexp := aexp
+
+	refresh := info.GetRefresh()
+	if refresh != "" {
+		rexp = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()).Sub(ct)
+		if aexp.Seconds() > rexp.Seconds() {
+			aexp = rexp
+		}
+	}
+
+	_, err = s.exec(ctx, `INSERT INTO `+sqlTokenTable+
+		` (basic_id, user_id, family_id, access, access_expires_at, refresh, expires_at, data, created_at)`+
+		` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		basicID, info.GetUserID(), nullString(info.GetFamilyID()), nullString(info.GetAccess()), expireAt(ct, aexp),
+		nullString(refresh), expireAt(ct, rexp), string(jv), ct.UnixNano())
+	return err
+}
+
+// RemoveByCode Use the authorization code to delete the token information
+func (s *SQLTokenStore) RemoveByCode(ctx context.Context, code string) error {
+	_, err := s.exec(ctx, `DELETE FROM `+sqlCodeTable+` WHERE code = ?`, code)
+	return err
+}
+
+// RemoveByAccess Use the access token to delete the token information
+func (s *SQLTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	return s.removeToken(ctx, access, false)
+}
+
+// RemoveByRefresh Use the refresh token to delete the token information
+func (s *SQLTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	return s.removeToken(ctx, refresh, true)
+}
+
+// GetByCode Use the authorization code for token information data
+func (s *SQLTokenStore) GetByCode(ctx context.Context, code string) (jwts.TokenInfo, error) {
+	now := time.Now().UnixNano()
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM `+sqlCodeTable+
+		` WHERE code = ? AND (expires_at = 0 OR expires_at > ?)`), code, now)
+	return s.parseToken(row)
+}
+
+// TakeByCode get and remove the authorization code, only the caller whose delete
+// affected the row gets it
+func (s *SQLTokenStore) TakeByCode(ctx context.Context, code string) (jwts.TokenInfo, error) {
+	ti, err := s.GetByCode(ctx, code)
+	if err != nil || ti == nil {
+		return nil, err
+	}
+	res, err := s.exec(ctx, `DELETE FROM `+sqlCodeTable+` WHERE code = ?`, code)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n != 1 {
+		return nil, nil
+	}
+	return ti, nil
+}
+
+// GetByAccess Use the access token for token information data
+func (s *SQLTokenStore) GetByAccess(ctx context.Context, access string) (jwts.TokenInfo, error) {
+	now := time.Now().UnixNano()
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM `+sqlTokenTable+
+		` WHERE access = ? AND (access_expires_at = 0 OR access_expires_at > ?)`), access, now)
+	return s.parseToken(row)
+}
+
+// GetByRefresh Use the refresh token for token information data
+func (s *SQLTokenStore) GetByRefresh(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+	now := time.Now().UnixNano()
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM `+sqlTokenTable+
+		` WHERE refresh = ? AND (expires_at = 0 OR expires_at > ?)`), refresh, now)
+	return s.parseToken(row)
+}
+
+// GetByUserID the token information of every session of the user
+func (s *SQLTokenStore) GetByUserID(ctx context.Context, userID string) ([]jwts.TokenInfo, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	now := time.Now().UnixNano()
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT data FROM `+sqlTokenTable+
+		` WHERE user_id = ? AND (expires_at = 0 OR expires_at > ?)`), userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []jwts.TokenInfo
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var token models.Token
+		if err := jsonUnmarshal([]byte(data), &token); err != nil {
+			return nil, err
+		}
+		infos = append(infos, &token)
+	}
+	return infos, rows.Err()
+}
+
+// RemoveToken Use the jti to delete the token information data
+func (s *SQLTokenStore) RemoveToken(ctx context.Context, jti string) error {
+	// tokens of a client without user aren't removed by user
+	if jti == "" {
+		return nil
+	}
+	_, err := s.exec(ctx, `DELETE FROM `+sqlTokenTable+` WHERE user_id = ?`, jti)
+	return err
+}
+
+// RemoveByToken Use the access or the refresh token to delete the whole session
+func (s *SQLTokenStore) RemoveByToken(ctx context.Context, token string) error {
+	_, err := s.exec(ctx, `DELETE FROM `+sqlTokenTable+` WHERE access = ? OR refresh = ?`, token, token)
+	return err
+}
+
+// MarkRotated remember a refresh token which has been rotated, until exp, false if it has been marked
+func (s *SQLTokenStore) MarkRotated(ctx context.Context, refresh string, info jwts.TokenInfo, exp time.Duration) (bool, error) {
+	jv, err := jsonMarshal(info)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	// an expired mark which hasn't been purged doesn't hold the key
+	_, err = s.exec(ctx, `DELETE FROM `+sqlRotatedTable+` WHERE refresh = ? AND expires_at <> 0 AND expires_at <= ?`,
+		refresh, now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	res, err := s.exec(ctx, `INSERT INTO `+sqlRotatedTable+` (refresh, data, expires_at) VALUES (?, ?, ?)`+
+		` ON CONFLICT (refresh) DO NOTHING`, refresh, string(jv), expireAt(now, exp))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// GetRotated the token information of a rotated refresh token
+func (s *SQLTokenStore) GetRotated(ctx context.Context, refresh string) (jwts.TokenInfo, error) {
+	now := time.Now().UnixNano()
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT data FROM `+sqlRotatedTable+
+		` WHERE refresh = ? AND (expires_at = 0 OR expires_at > ?)`), refresh, now)
+	return s.parseToken(row)
+}
+
+// RemoveFamily delete every token of the family
+func (s *SQLTokenStore) RemoveFamily(ctx context.Context, userID, familyID string) error {
+	_, err := s.exec(ctx, `DELETE FROM `+sqlTokenTable+` WHERE user_id = ? AND family_id = ?`, userID, familyID)
+	return err
+}
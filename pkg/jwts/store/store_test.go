@@ -0,0 +1,444 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	_ "modernc.org/sqlite"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/models"
+)
+
+// stores every backend of the TokenStore contract
+var stores = []struct {
+	name string
+	new  func(t *testing.T) jwts.TokenStore
+}{
+	{"memory", newTestMemoryStore},
+	{"sql", newTestSQLStore},
+	{"redis", newTestRedisStore},
+}
+
+func newTestMemoryStore(t *testing.T) jwts.TokenStore {
+	s := NewMemoryTokenStore()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newTestSQLStore(t *testing.T) jwts.TokenStore {
+	dsn := "file:" + filepath.Join(t.TempDir(), "tokens.db") + "?_pragma=busy_timeout(5000)"
+	s, err := OpenSQLTokenStore("sqlite", dsn, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestRedisStore the store on miniredis, the commands whose keys are in
+// different slots fail as they do in the cluster warden runs on.
+// miniredis can't serve the COMMAND of a cluster client, so a plain one is used
+func newTestRedisStore(t *testing.T) jwts.TokenStore {
+	cli := newTestRedisClient(t)
+	s := NewRedisStoreWithCli(cli, "test:")
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newTestRedisClient(t *testing.T) *redis.Client {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cli.AddHook(crossSlotHook{})
+	return cli
+}
+
+// crossSlotHook reject a multi key command whose keys hash to different slots with CROSSSLOT
+type crossSlotHook struct{}
+
+func (crossSlotHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, checkSlots(cmd)
+}
+
+func (crossSlotHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (crossSlotHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := checkSlots(cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (crossSlotHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func checkSlots(cmd redis.Cmder) error {
+	var keys []interface{}
+	args := cmd.Args()
+	switch cmd.Name() {
+	case "del", "unlink", "exists", "touch", "mget":
+		keys = args[1:]
+	case "eval", "evalsha":
+		if len(args) > 2 {
+			n, _ := args[2].(int)
+			keys = args[3 : 3+n]
+		}
+	}
+	for _, key := range keys {
+		if keySlot(fmt.Sprint(key)) != keySlot(fmt.Sprint(keys[0])) {
+			return fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot: %v", args)
+		}
+	}
+	return nil
+}
+
+// keySlot the cluster slot of the key, CRC16 XMODEM of the hash tag or the key
+func keySlot(key string) uint16 {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+func TestKeySlot(t *testing.T) {
+	// CLUSTER KEYSLOT of a real cluster
+	for key, slot := range map[string]uint16{
+		"foo":          12182,
+		"bar":          5061,
+		"{user1000}.a": 3443,
+		"{user1000}.b": 3443,
+		"123456789":    12739,
+	} {
+		if got := keySlot(key); got != slot {
+			t.Errorf("keySlot(%q) = %d, want %d", key, got, slot)
+		}
+	}
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, s jwts.TokenStore)) {
+	for _, v := range stores {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			test(t, v.new(t))
+		})
+	}
+}
+
+func newTestToken(userID, access, refresh, familyID string) *models.Token {
+	now := time.Now()
+	ti := models.NewToken()
+	ti.SetUserID(userID)
+	ti.SetClientID("client")
+	ti.SetFamilyID(familyID)
+	ti.SetAccess(access)
+	ti.SetAccessCreateAt(now)
+	ti.SetAccessExpiresIn(time.Hour)
+	ti.SetRefresh(refresh)
+	ti.SetRefreshCreateAt(now)
+	ti.SetRefreshExpiresIn(24 * time.Hour)
+	return ti
+}
+
+func newTestCode(code string) *models.Token {
+	ti := models.NewToken()
+	ti.SetUserID("user")
+	ti.SetClientID("client")
+	ti.SetCode(code)
+	ti.SetCodeCreateAt(time.Now())
+	ti.SetCodeExpiresIn(time.Minute)
+	return ti
+}
+
+func mustCreate(t *testing.T, s jwts.TokenStore, ti jwts.TokenInfo) {
+	t.Helper()
+	if err := s.Create(context.Background(), ti); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+// session the token information of the access and the refresh token, nil when it is removed
+func session(t *testing.T, s jwts.TokenStore, access, refresh string) (jwts.TokenInfo, jwts.TokenInfo) {
+	t.Helper()
+	ctx := context.Background()
+	byAccess, err := s.GetByAccess(ctx, access)
+	if err != nil {
+		t.Fatalf("GetByAccess: %v", err)
+	}
+	byRefresh, err := s.GetByRefresh(ctx, refresh)
+	if err != nil {
+		t.Fatalf("GetByRefresh: %v", err)
+	}
+	return byAccess, byRefresh
+}
+
+func userSessions(t *testing.T, s jwts.TokenStore, userID string) []jwts.TokenInfo {
+	t.Helper()
+	infos, err := s.GetByUserID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetByUserID: %v", err)
+	}
+	return infos
+}
+
+func TestCreateAndGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+		mustCreate(t, s, newTestToken("user", "access", "refresh", "family"))
+
+		byAccess, byRefresh := session(t, s, "access", "refresh")
+		for name, ti := range map[string]jwts.TokenInfo{"access": byAccess, "refresh": byRefresh} {
+			if ti == nil {
+				t.Fatalf("no token information by the %s token", name)
+			}
+			if ti.GetUserID() != "user" || ti.GetClientID() != "client" || ti.GetFamilyID() != "family" ||
+				ti.GetAccess() != "access" || ti.GetRefresh() != "refresh" {
+				t.Errorf("token information by the %s token: %+v", name, ti)
+			}
+		}
+		if infos := userSessions(t, s, "user"); len(infos) != 1 || infos[0].GetAccess() != "access" {
+			t.Errorf("sessions of the user: %+v", infos)
+		}
+
+		byAccess, byRefresh = session(t, s, "unknown", "unknown")
+		if byAccess != nil || byRefresh != nil {
+			t.Error("token information of an unknown token")
+		}
+		if infos := userSessions(t, s, "nobody"); len(infos) != 0 {
+			t.Errorf("sessions of an unknown user: %+v", infos)
+		}
+	})
+}
+
+func TestRemoveByToken(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"access", "access-1"},
+		{"refresh", "refresh-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+				ctx := context.Background()
+				mustCreate(t, s, newTestToken("user", "access-1", "refresh-1", "family-1"))
+				mustCreate(t, s, newTestToken("user", "access-2", "refresh-2", "family-2"))
+
+				if err := s.RemoveByToken(ctx, tc.token); err != nil {
+					t.Fatalf("RemoveByToken: %v", err)
+				}
+				if byAccess, byRefresh := session(t, s, "access-1", "refresh-1"); byAccess != nil || byRefresh != nil {
+					t.Error("the removed session is still found")
+				}
+				if byAccess, byRefresh := session(t, s, "access-2", "refresh-2"); byAccess == nil || byRefresh == nil {
+					t.Error("the other session of the user is removed")
+				}
+				if infos := userSessions(t, s, "user"); len(infos) != 1 || infos[0].GetAccess() != "access-2" {
+					t.Errorf("sessions of the user: %+v", infos)
+				}
+
+				// a removed or an unknown token is nothing to remove
+				if err := s.RemoveByToken(ctx, tc.token); err != nil {
+					t.Errorf("RemoveByToken of a removed token: %v", err)
+				}
+				if err := s.RemoveByToken(ctx, "unknown"); err != nil {
+					t.Errorf("RemoveByToken of an unknown token: %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestRemoveByAccessAndRefresh(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+		ctx := context.Background()
+		mustCreate(t, s, newTestToken("user", "access", "refresh", "family"))
+
+		if err := s.RemoveByAccess(ctx, "access"); err != nil {
+			t.Fatalf("RemoveByAccess: %v", err)
+		}
+		byAccess, byRefresh := session(t, s, "access", "refresh")
+		if byAccess != nil {
+			t.Error("the removed access token is still found")
+		}
+		if byRefresh == nil {
+			t.Fatal("the refresh token is removed with the access token")
+		}
+
+		if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+			t.Fatalf("RemoveByRefresh: %v", err)
+		}
+		if _, byRefresh = session(t, s, "access", "refresh"); byRefresh != nil {
+			t.Error("the removed refresh token is still found")
+		}
+	})
+}
+
+func TestTakeByCode(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+		ctx := context.Background()
+		mustCreate(t, s, newTestCode("code"))
+
+		ti, err := s.GetByCode(ctx, "code")
+		if err != nil || ti == nil || ti.GetUserID() != "user" {
+			t.Fatalf("GetByCode = %+v, %v", ti, err)
+		}
+
+		// the parallel exchanges of the code, only one of them gets it
+		const n = 10
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			taken int
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ti, err := s.TakeByCode(ctx, "code")
+				if err != nil {
+					t.Errorf("TakeByCode: %v", err)
+					return
+				}
+				if ti != nil {
+					if ti.GetUserID() != "user" || ti.GetCode() != "code" {
+						t.Errorf("TakeByCode = %+v", ti)
+					}
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if taken != 1 {
+			t.Errorf("the code is taken %d times, want once", taken)
+		}
+
+		if ti, err = s.GetByCode(ctx, "code"); err != nil || ti != nil {
+			t.Errorf("GetByCode of a taken code = %+v, %v", ti, err)
+		}
+		if ti, err = s.TakeByCode(ctx, "unknown"); err != nil || ti != nil {
+			t.Errorf("TakeByCode of an unknown code = %+v, %v", ti, err)
+		}
+	})
+}
+
+func TestMarkRotated(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+		ctx := context.Background()
+		rotated := models.NewToken()
+		rotated.SetUserID("user")
+		rotated.SetFamilyID("family")
+
+		// the parallel refreshes with the same token, only one of them claims it
+		const n = 10
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			claimed int
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := s.MarkRotated(ctx, "refresh", rotated, time.Hour)
+				if err != nil {
+					t.Errorf("MarkRotated: %v", err)
+					return
+				}
+				if ok {
+					mu.Lock()
+					claimed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if claimed != 1 {
+			t.Errorf("the refresh token is claimed %d times, want once", claimed)
+		}
+
+		// a reuse of the rotated token is told by its family
+		ti, err := s.GetRotated(ctx, "refresh")
+		if err != nil || ti == nil {
+			t.Fatalf("GetRotated = %+v, %v", ti, err)
+		}
+		if ti.GetUserID() != "user" || ti.GetFamilyID() != "family" {
+			t.Errorf("GetRotated = %+v", ti)
+		}
+		if ti, err = s.GetRotated(ctx, "unknown"); err != nil || ti != nil {
+			t.Errorf("GetRotated of an unknown token = %+v, %v", ti, err)
+		}
+	})
+}
+
+func TestRemoveFamily(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s jwts.TokenStore) {
+		ctx := context.Background()
+		mustCreate(t, s, newTestToken("user", "access-1", "refresh-1", "family-1"))
+		mustCreate(t, s, newTestToken("user", "access-2", "refresh-2", "family-1"))
+		mustCreate(t, s, newTestToken("user", "access-3", "refresh-3", "family-2"))
+
+		if err := s.RemoveFamily(ctx, "user", "family-1"); err != nil {
+			t.Fatalf("RemoveFamily: %v", err)
+		}
+		for _, v := range []string{"1", "2"} {
+			if byAccess, byRefresh := session(t, s, "access-"+v, "refresh-"+v); byAccess != nil || byRefresh != nil {
+				t.Errorf("the session %s of the removed family is still found", v)
+			}
+		}
+		if byAccess, byRefresh := session(t, s, "access-3", "refresh-3"); byAccess == nil || byRefresh == nil {
+			t.Error("the session of the other family is removed")
+		}
+		if infos := userSessions(t, s, "user"); len(infos) != 1 || infos[0].GetFamilyID() != "family-2" {
+			t.Errorf("sessions of the user: %+v", infos)
+		}
+	})
+}
+
+// TestRedisClusterMultiKeyDel the cross slot check fails a DEL of the keys apart in a cluster
+func TestRedisClusterMultiKeyDel(t *testing.T) {
+	cli := newTestRedisClient(t)
+	defer cli.Close()
+
+	ctx := context.Background()
+	a, b := JWTRedis+"access", JWTRedis+"basic"
+	if keySlot(a) == keySlot(b) {
+		t.Fatalf("%s and %s are in the same slot", a, b)
+	}
+	if err := cli.Del(ctx, a, b).Err(); err == nil || !strings.HasPrefix(err.Error(), "CROSSSLOT") {
+		t.Errorf("Del of the keys apart = %v, want CROSSSLOT", err)
+	}
+	pipe := cli.TxPipeline()
+	pipe.Del(ctx, a, b)
+	if _, err := pipe.Exec(ctx); err == nil || !strings.HasPrefix(err.Error(), "CROSSSLOT") {
+		t.Errorf("pipelined Del of the keys apart = %v, want CROSSSLOT", err)
+	}
+	if err := cli.Del(ctx, a).Err(); err != nil {
+		t.Errorf("Del of one key: %v", err)
+	}
+}
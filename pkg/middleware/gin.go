@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin the gin middleware, the request is aborted with 401 as the check endpoint does
+func Gin(validate Validator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := authenticate(validate, c.Request)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// GinRequireTenant the identity must belong to a tenant, one of the tenants when they are given
+func GinRequireTenant(tenantIDs ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := FromContext(c.Request.Context())
+		if !hasTenant(id, tenantIDs) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GinRequireDepartment the identity must belong to a department,
+// one of the departments or their sub departments when they are given
+func GinRequireDepartment(depIDs ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := FromContext(c.Request.Context())
+		if !hasDepartment(id, depIDs) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
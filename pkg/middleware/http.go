@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Handler the net/http middleware, the request is answered with 401 as the check endpoint does
+func Handler(validate Validator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := authenticate(validate, r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// RequireTenant the identity must belong to a tenant, one of the tenants when they are given
+func RequireTenant(next http.Handler, tenantIDs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := FromContext(r.Context())
+		if !hasTenant(id, tenantIDs) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireDepartment the identity must belong to a department,
+// one of the departments or their sub departments when they are given
+func RequireDepartment(next http.Handler, depIDs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := FromContext(r.Context())
+		if !hasDepartment(id, depIDs) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,172 @@
+// Package middleware the authentication middleware of the services behind warden,
+// it keeps the header contract of the check endpoint
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quanxiang-cloud/warden/pkg/verifier"
+)
+
+// the header contract of the check endpoint
+const (
+	AccessToken  = "Access-Token"
+	UserID       = "User-Id"
+	UserName     = "User-Name"
+	DepartmentID = "Department-Id"
+	TenantID     = "Tenant-Id"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Validator validate the token and return its identity
+type Validator func(ctx context.Context, token string) (*verifier.Identity, error)
+
+// Local validate the tokens locally by the public keys of warden, the identity is the claims
+// as the token was issued, so a tenant switched by /switch/tenant isn't seen. Use Remote
+// where the users switch tenants.
+func Local(v *verifier.Verifier) Validator {
+	return v.Verify
+}
+
+// Remote validate the tokens by warden's check endpoint,
+// such as http://warden/api/v1/warden/check
+func Remote(checkURL string, client *http.Client) Validator {
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	return func(ctx context.Context, token string) (*verifier.Identity, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(AccessToken, token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, verifier.ErrInvalidToken
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("middleware: check token: %s", resp.Status)
+		}
+		if resp.Header.Get(UserID) == "" {
+			return nil, verifier.ErrInvalidToken
+		}
+		depID := resp.Header.Get(DepartmentID)
+		return &verifier.Identity{
+			UserID:        resp.Header.Get(UserID),
+			Name:          resp.Header.Get(UserName),
+			TenantID:      resp.Header.Get(TenantID),
+			DepartmentID:  depID,
+			DepartmentIDs: departmentIDs(depID),
+		}, nil
+	}
+}
+
+// departmentIDs the department paths of the Department-Id header,
+// ids of a path are joined by "," and the paths by "|"
+func departmentIDs(depID string) [][]string {
+	if depID == "" {
+		return nil
+	}
+	paths := strings.Split(depID, "|")
+	res := make([][]string, 0, len(paths))
+	for _, v := range paths {
+		res = append(res, strings.Split(v, ","))
+	}
+	return res
+}
+
+type identityKey struct{}
+
+// NewContext the context carries the identity
+func NewContext(ctx context.Context, id *verifier.Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext the identity the middleware put into the context
+func FromContext(ctx context.Context) (*verifier.Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*verifier.Identity)
+	return id, ok && id != nil
+}
+
+// token the Access-Token header, or the bearer token of the Authorization header
+func token(r *http.Request) string {
+	if v := r.Header.Get(AccessToken); v != "" {
+		return v
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// authenticate validate the token of the request and set the identity headers,
+// the headers the client sent are replaced so they can't be forged
+func authenticate(validate Validator, r *http.Request) (*verifier.Identity, bool) {
+	t := token(r)
+	if t == "" {
+		return nil, false
+	}
+	id, err := validate(r.Context(), t)
+	if err != nil || id == nil || id.UserID == "" {
+		return nil, false
+	}
+	setHeader(r.Header, UserID, id.UserID)
+	setHeader(r.Header, UserName, id.Name)
+	setHeader(r.Header, DepartmentID, id.DepartmentID)
+	setHeader(r.Header, TenantID, id.TenantID)
+	return id, true
+}
+
+func setHeader(header http.Header, key, value string) {
+	if value == "" {
+		header.Del(key)
+		return
+	}
+	header.Set(key, value)
+}
+
+// hasTenant the identity belongs to a tenant, one of the tenants when they are given
+func hasTenant(id *verifier.Identity, tenantIDs []string) bool {
+	if id == nil || id.TenantID == "" {
+		return false
+	}
+	if len(tenantIDs) == 0 {
+		return true
+	}
+	for _, v := range tenantIDs {
+		if v == id.TenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDepartment the identity belongs to a department, one of the departments or
+// their sub departments when they are given
+func hasDepartment(id *verifier.Identity, depIDs []string) bool {
+	if id == nil || len(id.DepartmentIDs) == 0 {
+		return false
+	}
+	if len(depIDs) == 0 {
+		return true
+	}
+	for _, path := range id.DepartmentIDs {
+		for _, dep := range path {
+			for _, v := range depIDs {
+				if v == dep {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
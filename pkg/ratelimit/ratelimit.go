@@ -0,0 +1,60 @@
+// Package ratelimit the sliding window rate limiter shared by the instances through redis
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// keyPrefix the requests of a key are a sorted set scored by the unix milliseconds
+const keyPrefix = "warden:ratelimit:"
+
+// slidingWindow drop the requests out of the window, the request is added when there is room.
+// It returns 0 when the request is allowed, or the milliseconds until the oldest one leaves the window.
+var slidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 0
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local wait = tonumber(oldest[2]) + window - now
+if wait < 1 then
+	wait = 1
+end
+return wait
+`)
+
+// Limiter the sliding window limiter
+type Limiter struct {
+	redisc redis.UniversalClient
+}
+
+// New create a limiter
+func New(redisc redis.UniversalClient) *Limiter {
+	return &Limiter{
+		redisc: redisc,
+	}
+}
+
+// Allow whether the request of the key is within limit requests per window,
+// the retry after is how long the caller waits when it isn't
+func (l *Limiter) Allow(ctx context.Context, key string, limit int64, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	wait, err := slidingWindow.Run(ctx, l.redisc, []string{keyPrefix + key},
+		now, window.Milliseconds(), limit, uuid.New().String()).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	if wait == 0 {
+		return true, 0, nil
+	}
+	return false, time.Duration(wait) * time.Millisecond, nil
+}
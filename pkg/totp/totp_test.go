@@ -0,0 +1,125 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfcVectors the SHA1 vectors of RFC 6238 Appendix B, the 6 digit codes are
+// the last digits of the 8 digit ones
+var rfcVectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{59, 0x1, "287082"},
+	{1111111109, 0x23523EC, "081804"},
+	{1111111111, 0x23523ED, "050471"},
+	{1234567890, 0x273EF07, "005924"},
+	{2000000000, 0x3F940AA, "279037"},
+	{20000000000, 0x27BC86AA, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		step := Counter(time.Unix(v.unix, 0))
+		if step != v.step {
+			t.Errorf("Counter(%d) = %X, want %X", v.unix, step, v.step)
+		}
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("Code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	// the secrets are typed grouped, in lower case or padded
+	for _, secret := range []string{
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		rfcSecret + "====",
+	} {
+		code, err := Code(secret, 1)
+		if err != nil {
+			t.Fatalf("Code(%q): %v", secret, err)
+		}
+		if code != "287082" {
+			t.Errorf("Code(%q) = %s, want 287082", secret, code)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code of an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		step, ok := Validate(rfcSecret, v.code, time.Unix(v.unix, 0), 1)
+		if !ok || step != v.step {
+			t.Errorf("Validate(%s) at %d = %X %v, want %X true", v.code, v.unix, step, ok, v.step)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	// 1111111111 is the step 0x23523ED, the code of 1111111109 is the previous step
+	now := time.Unix(1111111111, 0)
+	for _, tc := range []struct {
+		name string
+		at   time.Time
+		skew int64
+		ok   bool
+	}{
+		{"same step", time.Unix(1111111109, 0), 0, true},
+		{"next step without skew", now, 0, false},
+		{"next step within skew", now, 1, true},
+		{"previous step within skew", time.Unix(1111111109-Period, 0), 1, true},
+		{"two steps later", now.Add(Period * time.Second), 1, false},
+		{"two steps earlier", time.Unix(1111111109-2*Period, 0), 1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, "081804", tc.at, tc.skew)
+			if ok != tc.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tc.ok)
+			}
+			if ok && step != 0x23523EC {
+				t.Errorf("step %X, want the step of the code 23523EC", step)
+			}
+		})
+	}
+}
+
+func TestValidateStepReuse(t *testing.T) {
+	// a code accepted again within the skew window reports the same step,
+	// so the caller refusing a used step refuses the replay
+	first, ok := Validate(rfcSecret, "050471", time.Unix(1111111111, 0), 1)
+	if !ok {
+		t.Fatal("code refused")
+	}
+	replay, ok := Validate(rfcSecret, "050471", time.Unix(1111111111+Period, 0), 1)
+	if !ok {
+		t.Fatal("code refused within the skew window")
+	}
+	if first != replay {
+		t.Errorf("steps %X and %X differ, the replay can't be told", first, replay)
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "94287082", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate(%q) succeeded", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 287082 ", now, 0); !ok {
+		t.Error("Validate of a code with spaces around it failed")
+	}
+}
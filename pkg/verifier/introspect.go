@@ -0,0 +1,46 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// introspect ask warden whether the token is still active, RFC 7662.
+// It fails closed, the token isn't accepted when warden can't answer.
+func (v *Verifier) introspect(ctx context.Context, token string) error {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.conf.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if v.conf.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(v.conf.ClientID), url.QueryEscape(v.conf.ClientSecret))
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifier: introspect: %s", resp.Status)
+	}
+	res := struct {
+		Active bool `json:"active"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.Active {
+		return ErrRevokedToken
+	}
+	return nil
+}
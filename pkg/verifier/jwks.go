@@ -0,0 +1,187 @@
+package verifier
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts"
+	"github.com/quanxiang-cloud/warden/pkg/jwts/generates"
+)
+
+// minRefetch an unknown kid refetches the keys at most once in the interval,
+// so forged kids can't flood warden. After a failed fetch the keys aren't fetched
+// for the interval either, whether they are cached or not.
+const minRefetch = 30 * time.Second
+
+type verifyKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// keySet the cached public keys by kid, a rotated key is fetched when a token carries its kid.
+// One fetch runs at a time apart from the callers, the callers with a cached key don't wait for it.
+type keySet struct {
+	url     string
+	ttl     time.Duration
+	client  *http.Client
+	timeout time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]verifyKey
+	expiresAt time.Time
+	fetchAt   time.Time
+	// fetching is closed when the running fetch is done, nil when there is none
+	fetching chan struct{}
+	// err and failAt the last failed fetch, err is nil after a fetch succeeds
+	err    error
+	failAt time.Time
+}
+
+func newKeySet(url string, ttl time.Duration, client *http.Client) *keySet {
+	timeout := client.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &keySet{
+		url:     url,
+		ttl:     ttl,
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+func (s *keySet) get(ctx context.Context, kid string) (verifyKey, error) {
+	s.mu.Lock()
+	k, ok := s.keys[kid]
+	switch {
+	case ok && time.Now().Before(s.expiresAt):
+		s.mu.Unlock()
+		return k, nil
+	case ok && s.fetching != nil:
+		// the cached key is used while the keys are being fetched
+		s.mu.Unlock()
+		return k, nil
+	case s.err != nil && time.Since(s.failAt) < minRefetch:
+		// warden is tried again after the interval, the cached keys are used until then
+		s.mu.Unlock()
+		if ok {
+			return k, nil
+		}
+		return verifyKey{}, s.err
+	case !ok && s.keys != nil && time.Since(s.fetchAt) < minRefetch:
+		s.mu.Unlock()
+		return verifyKey{}, ErrInvalidToken
+	}
+
+	done := s.fetching
+	if done == nil {
+		done = make(chan struct{})
+		s.fetching = done
+		s.fetchAt = time.Now()
+		go s.refresh(done)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return verifyKey{}, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok = s.keys[kid]; ok {
+		return k, nil
+	}
+	if s.err != nil {
+		return verifyKey{}, s.err
+	}
+	return verifyKey{}, ErrInvalidToken
+}
+
+// refresh fetch the keys apart from the context of any caller, so a canceled request
+// doesn't fail the callers waiting for the same fetch
+func (s *keySet) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	keys, expiresAt, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.keys, s.expiresAt = keys, expiresAt
+	} else {
+		s.failAt = time.Now()
+	}
+	s.err = err
+	s.fetching = nil
+	close(done)
+}
+
+// fetch the keys and when they expire
+func (s *keySet) fetch(ctx context.Context) (map[string]verifyKey, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("verifier: fetch jwks: %s", resp.Status)
+	}
+	set := jwts.JSONWebKeySet{}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	keys := make(map[string]verifyKey, len(set.Keys))
+	for _, v := range set.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		method, key, err := generates.ParseJWK(v)
+		if err != nil {
+			continue
+		}
+		keys[v.Kid] = verifyKey{method: method, key: key}
+	}
+	return keys, time.Now().Add(maxAge(resp.Header, s.ttl)), nil
+}
+
+// maxAge the max-age of Cache-Control, the ttl when there is none
+func maxAge(header http.Header, ttl time.Duration) time.Duration {
+	for _, v := range strings.Split(header.Get("Cache-Control"), ",") {
+		v = strings.TrimSpace(v)
+		if !strings.HasPrefix(v, "max-age=") {
+			continue
+		}
+		if sec, err := strconv.Atoi(strings.TrimPrefix(v, "max-age=")); err == nil && sec > 0 {
+			return time.Duration(sec) * time.Second
+		}
+	}
+	return ttl
+}
+
+func decodeHeader(token string, header interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, header)
+}
@@ -0,0 +1,169 @@
+// Package verifier verify the access tokens of warden inside the other services,
+// the public keys are fetched from the jwks endpoint and cached
+package verifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/quanxiang-cloud/warden/pkg/jwts/generates"
+)
+
+const (
+	defaultCacheTTL = time.Hour
+	defaultTimeout  = 10 * time.Second
+)
+
+var (
+	// ErrInvalidToken the token isn't signed by warden, has expired or isn't meant for the service
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrRevokedToken the introspection reports the token isn't active
+	ErrRevokedToken = errors.New("revoked token")
+)
+
+// Config the verifier config
+type Config struct {
+	// JWKSURL warden's jwks endpoint, such as http://warden/.well-known/jwks.json
+	JWKSURL string
+	// HMACKey the key of the HS tokens, they have no public key
+	HMACKey []byte
+
+	// Issuer and Audience are verified when they are set, as warden does
+	Issuer   string
+	Audience string
+	// AcceptLegacy accept the tokens issued before the claims model during the migration
+	AcceptLegacy bool
+
+	// CacheTTL how long the keys are cached when the endpoint doesn't say, default 1h
+	CacheTTL time.Duration
+
+	// IntrospectionURL the revocation status is asked for every token when it is set,
+	// the client authenticates the introspection request
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	HTTPClient *http.Client
+}
+
+// Identity the verified identity of the token
+type Identity struct {
+	UserID        string
+	Name          string
+	TenantID      string
+	DepartmentID  string
+	DepartmentIDs [][]string
+	ClientID      string
+	Scope         string
+	SessionID     string
+	ExpiresAt     time.Time
+
+	Claims *generates.JWTAccessClaims
+}
+
+// Verifier verify the access tokens without a round trip to warden
+type Verifier struct {
+	conf    Config
+	opts    generates.ClaimsOptions
+	client  *http.Client
+	keys    *keySet
+	hmacKey []byte
+}
+
+// New create a verifier
+func New(conf Config) (*Verifier, error) {
+	if conf.JWKSURL == "" && len(conf.HMACKey) == 0 {
+		return nil, errors.New("verifier: jwks url or hmac key is required")
+	}
+	if conf.CacheTTL <= 0 {
+		conf.CacheTTL = defaultCacheTTL
+	}
+	client := conf.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	v := &Verifier{
+		conf: conf,
+		opts: generates.ClaimsOptions{
+			Issuer:       conf.Issuer,
+			Audience:     conf.Audience,
+			AcceptLegacy: conf.AcceptLegacy,
+		},
+		client:  client,
+		hmacKey: conf.HMACKey,
+	}
+	if conf.JWKSURL != "" {
+		v.keys = newKeySet(conf.JWKSURL, conf.CacheTTL, client)
+	}
+	return v, nil
+}
+
+// Verify verify the signature, expiry, issuer and audience of the token,
+// and its revocation status when the introspection is configured
+func (v *Verifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	method, key, err := v.key(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := v.opts.VerifyClaims(token, method, key)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if v.conf.IntrospectionURL != "" {
+		if err = v.introspect(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	return newIdentity(claims), nil
+}
+
+// key the method and the key of the token by its header,
+// the alg of the header must be the alg of the key
+func (v *Verifier) key(ctx context.Context, token string) (jwt.SigningMethod, interface{}, error) {
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeHeader(token, &header); err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if strings.HasPrefix(header.Alg, "HS") {
+		method := jwt.GetSigningMethod(header.Alg)
+		if method == nil || len(v.hmacKey) == 0 {
+			return nil, nil, ErrInvalidToken
+		}
+		return method, v.hmacKey, nil
+	}
+	if v.keys == nil {
+		return nil, nil, ErrInvalidToken
+	}
+	k, err := v.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if k.method.Alg() != header.Alg {
+		return nil, nil, ErrInvalidToken
+	}
+	return k.method, k.key, nil
+}
+
+func newIdentity(claims *generates.JWTAccessClaims) *Identity {
+	return &Identity{
+		UserID:        claims.UserID(),
+		Name:          claims.Name,
+		TenantID:      claims.TenantID,
+		DepartmentID:  claims.DepartmentID(),
+		DepartmentIDs: claims.DepartmentIDs,
+		ClientID:      claims.ClientID,
+		Scope:         claims.Scope,
+		SessionID:     claims.SessionID,
+		ExpiresAt:     time.Unix(claims.ExpiresAt, 0),
+		Claims:        claims,
+	}
+}